@@ -0,0 +1,107 @@
+package aof
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"github.com/8090Lambert/go-redis-parser/rdb"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Command is a command of the AOF stream, with the database selected when it was replayed.
+type Command struct {
+	DB   uint64
+	Args [][]byte
+}
+
+// Name of the command in upper case.
+func (c Command) Name() string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	return strings.ToUpper(string(c.Args[0]))
+}
+
+func (c Command) Type() string {
+	return protocol.Command
+}
+
+func (c Command) String() string {
+	return fmt.Sprintf("{Command: {DB: %d, Args: %s}}", c.DB, c.Value())
+}
+
+// Commands of the AOF whose first argument is not a key: a database, a script, a library...
+var keylessCommands = map[string]struct{}{
+	"SELECT": {}, "SWAPDB": {}, "FLUSHDB": {}, "FLUSHALL": {}, "MULTI": {}, "EXEC": {}, "DISCARD": {},
+	"SCRIPT": {}, "FUNCTION": {}, "EVAL": {}, "EVALSHA": {}, "FCALL": {}, "PUBLISH": {}, "PING": {},
+}
+
+// The first argument, which is the key for most of commands. Empty for the
+// commands without key, like SELECT whose argument is the database.
+func (c Command) Key() string {
+	if len(c.Args) < 2 {
+		return ""
+	}
+	if _, ok := keylessCommands[c.Name()]; ok {
+		return ""
+	}
+	return string(c.Args[1])
+}
+
+// Command line, arguments with spaces or unprintable bytes are quoted.
+func (c Command) Value() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, quoteArg(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+func (c Command) ValueLen() uint64 {
+	return uint64(len(c.Args))
+}
+
+func (c Command) ConcreteSize() uint64 {
+	var size uint64
+	for _, arg := range c.Args {
+		size += uint64(len(arg))
+	}
+	return size
+}
+
+// Command returns the arguments, the command is written as is by the resp gen-file.
+func (c Command) Command() []string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+// JSON record of the command: {"type":"command","db":0,"args":["SET","k","v"]}
+func (c Command) MarshalJSON() ([]byte, error) {
+	args := make([]rdb.BinaryString, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, rdb.BinaryString(arg))
+	}
+	return json.Marshal(struct {
+		Type string             `json:"type"`
+		DB   uint64             `json:"db"`
+		Args []rdb.BinaryString `json:"args"`
+	}{Type: "command", DB: c.DB, Args: args})
+}
+
+func quoteArg(arg []byte) string {
+	s := string(arg)
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == '"' || r == '\'' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
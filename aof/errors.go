@@ -0,0 +1,25 @@
+package aof
+
+import (
+	"fmt"
+)
+
+// ParseError describes the command of an AOF stream that failed to decode,
+// the cause is available through errors.Is and errors.As.
+type ParseError struct {
+	File   string // File of a multi part AOF, empty for a single file
+	Offset int64  // Offset in the stream where the failing command started
+	Line   int64  // Line of the commands where the failing command started, after the RDB preamble if any
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("aof: %s, offset %d, line %d: %s", e.File, e.Offset, e.Line, e.Err.Error())
+	}
+	return fmt.Sprintf("aof: offset %d, line %d: %s", e.Offset, e.Line, e.Err.Error())
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,259 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of the files of a multi part AOF (redis 7.0+).
+const (
+	ManifestBase    = 'b' // Snapshot of the dataset, RDB or AOF format
+	ManifestIncr    = 'i' // Commands written after the base
+	ManifestHistory = 'h' // Files of the previous rewrite, waiting to be deleted
+)
+
+const ManifestSuffix = ".manifest"
+
+// ManifestFile is a line of the manifest, like "file appendonly.aof.1.base.rdb seq 1 type b".
+type ManifestFile struct {
+	Name string
+	Seq  int64
+	Type byte
+}
+
+// Manifest lists the files of a multi part AOF, stored in appenddirname.
+type Manifest struct {
+	Dir   string         // Directory of the manifest and its files
+	Base  *ManifestFile  // Nil when the AOF has no base yet
+	Incrs []ManifestFile // Sorted by sequence
+}
+
+// Files returns the paths of the base and incr files, in the replay order.
+func (m *Manifest) Files() []string {
+	files := make([]string, 0, len(m.Incrs)+1)
+	if m.Base != nil {
+		files = append(files, filepath.Join(m.Dir, m.Base.Name))
+	}
+	for _, f := range m.Incrs {
+		files = append(files, filepath.Join(m.Dir, f.Name))
+	}
+	return files
+}
+
+// LoadManifest reads the manifest, path is either the manifest itself or
+// the appenddirname holding it.
+func LoadManifest(path string) (*Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		if path, err = findManifest(path); err != nil {
+			return nil, err
+		}
+	}
+	handler, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer handler.Close()
+
+	m := &Manifest{Dir: filepath.Dir(path)}
+	scanner := bufio.NewScanner(handler)
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		f, err := parseManifestLine(line)
+		if err != nil {
+			return nil, errors.New("invalid manifest line " + strconv.Itoa(num) + ": " + err.Error())
+		}
+		switch f.Type {
+		case ManifestBase:
+			if m.Base != nil {
+				return nil, errors.New("invalid manifest line " + strconv.Itoa(num) + ": found a second base file")
+			}
+			m.Base = &f
+		case ManifestIncr:
+			m.Incrs = append(m.Incrs, f)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.Base == nil && len(m.Incrs) == 0 {
+		return nil, errors.New("manifest " + path + " has no base or incr file")
+	}
+	sort.SliceStable(m.Incrs, func(i, j int) bool { return m.Incrs[i].Seq < m.Incrs[j].Seq })
+
+	return m, nil
+}
+
+// IsManifest whether the path is a manifest or a directory holding one.
+func IsManifest(path string) bool {
+	if strings.HasSuffix(path, ManifestSuffix) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func findManifest(dir string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	found := ""
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ManifestSuffix) {
+			continue
+		}
+		if found != "" {
+			return "", errors.New("more than one manifest found in " + dir)
+		}
+		found = filepath.Join(dir, entry.Name())
+	}
+	if found == "" {
+		return "", errors.New("no manifest found in " + dir)
+	}
+	return found, nil
+}
+
+func parseManifestLine(line string) (ManifestFile, error) {
+	var f ManifestFile
+	args, err := splitArgs(line)
+	if err != nil {
+		return f, err
+	}
+	if len(args)%2 != 0 {
+		return f, errors.New("odd number of fields")
+	}
+	var hasSeq bool
+	for i := 0; i < len(args); i += 2 {
+		switch args[i] {
+		case "file":
+			f.Name = args[i+1]
+		case "seq":
+			seq, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return f, errors.New("invalid seq '" + args[i+1] + "'")
+			}
+			f.Seq, hasSeq = seq, true
+		case "type":
+			if len(args[i+1]) != 1 {
+				return f, errors.New("invalid type '" + args[i+1] + "'")
+			}
+			f.Type = args[i+1][0]
+		}
+		// Unknown fields are ignored, as redis does for forward compatibility.
+	}
+	if f.Name == "" || !hasSeq || f.Type == 0 {
+		return f, errors.New("missing file, seq or type")
+	}
+	if f.Type != ManifestBase && f.Type != ManifestIncr && f.Type != ManifestHistory {
+		return f, errors.New("unknown type '" + string(f.Type) + "'")
+	}
+	if strings.ContainsAny(f.Name, "/\\") {
+		return f, errors.New("file name '" + f.Name + "' must not contain a path")
+	}
+	return f, nil
+}
+
+// splitArgs splits the line like sdssplitargs of redis, arguments are
+// separated by spaces and may be quoted, with C like escapes in double quotes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; ; {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if inDouble {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes")
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if line[i] == '"' {
+					// Closing quote must be followed by a space or nothing at all.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes")
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else if inSingle {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes")
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("unbalanced quotes")
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else {
+				if i == len(line) || isSpace(line[i]) {
+					done = true
+					break
+				}
+				switch line[i] {
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(arg))
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
@@ -0,0 +1,122 @@
+package aof
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Quoting of sdssplitargs.
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  string
+	}{
+		{line: "file appendonly.aof.1.incr.aof seq 1 type i", want: []string{"file", "appendonly.aof.1.incr.aof", "seq", "1", "type", "i"}},
+		{line: "  a \t b  ", want: []string{"a", "b"}},
+		{line: "", want: nil},
+		{line: `"a b" c`, want: []string{"a b", "c"}},
+		{line: `"" c`, want: []string{"", "c"}},
+		{line: `"\x41\x6a\n\r\t\b\a\"\\z"`, want: []string{"Aj\n\r\t\b\a\"\\z"}},
+		{line: `"\x4" "\xzz"`, want: []string{"x4", "xzz"}},
+		{line: `'a "b" \'c\' \n'`, want: []string{`a "b" 'c' \n`}},
+		{line: `"a`, err: "unbalanced quotes"},
+		{line: `'a`, err: "unbalanced quotes"},
+		{line: `"a"b`, err: "unbalanced quotes"},
+		{line: `'a'b`, err: "unbalanced quotes"},
+	}
+	for _, test := range tests {
+		args, err := splitArgs(test.line)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: error %v, want %q", test.line, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(args, test.want) {
+			t.Errorf("%q: %q, %v, want %q", test.line, args, err, test.want)
+		}
+	}
+}
+
+func TestParseManifestLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want ManifestFile
+		err  string
+	}{
+		{line: "file appendonly.aof.1.base.rdb seq 1 type b", want: ManifestFile{Name: "appendonly.aof.1.base.rdb", Seq: 1, Type: ManifestBase}},
+		{line: `file "append only.aof.2.incr.aof" seq 2 type i`, want: ManifestFile{Name: "append only.aof.2.incr.aof", Seq: 2, Type: ManifestIncr}},
+		{line: `type h seq 3 file 'appendonly.aof.3.incr.aof'`, want: ManifestFile{Name: "appendonly.aof.3.incr.aof", Seq: 3, Type: ManifestHistory}},
+		{line: "file appendonly.aof.4.incr.aof seq 4 type i startoffset 100", want: ManifestFile{Name: "appendonly.aof.4.incr.aof", Seq: 4, Type: ManifestIncr}},
+		{line: "file appendonly.aof.1.incr.aof seq 1 type", err: "odd number of fields"},
+		{line: "file appendonly.aof.1.incr.aof type i", err: "missing file, seq or type"},
+		{line: "file appendonly.aof.1.incr.aof seq one type i", err: "invalid seq 'one'"},
+		{line: "file appendonly.aof.1.incr.aof seq 1 type incr", err: "invalid type 'incr'"},
+		{line: "file appendonly.aof.1.incr.aof seq 1 type x", err: "unknown type 'x'"},
+		{line: "file ../appendonly.aof.1.incr.aof seq 1 type i", err: "file name '../appendonly.aof.1.incr.aof' must not contain a path"},
+		{line: `file "appendonly.aof seq 1 type i`, err: "unbalanced quotes"},
+	}
+	for _, test := range tests {
+		f, err := parseManifestLine(test.line)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: error %v, want %q", test.line, err, test.err)
+			}
+			continue
+		}
+		if err != nil || f != test.want {
+			t.Errorf("%q: %+v, %v, want %+v", test.line, f, err, test.want)
+		}
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(manifest string) string {
+		path := filepath.Join(dir, "appendonly.aof.manifest")
+		if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("# comment\n" +
+		"file appendonly.aof.3.incr.aof seq 3 type i\n" +
+		"\n" +
+		"file appendonly.aof.1.base.rdb seq 1 type b\n" +
+		"file appendonly.aof.1.incr.aof seq 1 type h\n" +
+		"file \"appendonly.aof.2.incr.aof\" seq 2 type i\n")
+	for _, file := range []string{path, dir} {
+		m, err := LoadManifest(file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		want := []string{
+			filepath.Join(dir, "appendonly.aof.1.base.rdb"),
+			filepath.Join(dir, "appendonly.aof.2.incr.aof"),
+			filepath.Join(dir, "appendonly.aof.3.incr.aof"),
+		}
+		if files := m.Files(); !reflect.DeepEqual(files, want) {
+			t.Errorf("%s: files are %q, want %q", file, files, want)
+		}
+	}
+
+	for manifest, want := range map[string]string{
+		"file a seq 1 type b\nfile b seq 2 type b\n": "invalid manifest line 2: found a second base file",
+		"file a seq 1 type b\nfile b seq 2 type\n":   "invalid manifest line 2: odd number of fields",
+		"# empty\nfile a seq 1 type h\n":             "has no base or incr file",
+	} {
+		if _, err := LoadManifest(write(manifest)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", manifest, err, want)
+		}
+	}
+}
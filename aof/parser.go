@@ -0,0 +1,246 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"github.com/8090Lambert/go-redis-parser/rdb"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Large enough for bufio.NewReader of the rdb.Decoder to reuse it, so the
+// preamble decoding does not read ahead into the commands.
+const readerSize = 1 << 16
+
+// ParseAof is the command line parser of AOF files, it replays the RDB
+// preamble and the commands of the file into the gen-file. A multi part
+// AOF is replayed from its base file, then every incr file in sequence.
+type ParseAof struct {
+	files            []string // Files to replay in order
+	multiPart        bool
+	lastIncr         string // Last incr file of a multi part AOF, its tail may be truncated
+	output           *os.File
+	skipZeroChecksum bool
+	writer           *rdb.WriterRDB
+	db               uint64            // Database selected by the last SELECT
+	commands         map[string]uint64 // Number of calls by command name
+	total            uint64
+}
+
+// NewAOF accepts a single AOF file, the manifest of a multi part AOF or its appenddirname.
+func NewAOF(file string, opts protocol.Options) (protocol.Parser, error) {
+	a := &ParseAof{files: []string{file}}
+	if IsManifest(file) {
+		manifest, err := LoadManifest(file)
+		if err != nil {
+			return nil, err
+		}
+		a.files, a.multiPart = manifest.Files(), true
+		if len(manifest.Incrs) > 0 {
+			a.lastIncr = a.files[len(a.files)-1]
+		}
+	}
+	writer, err := rdb.CreateGenFile(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	a.output = writer
+	a.skipZeroChecksum = opts.SkipZeroChecksum
+	a.writer = rdb.NewSummaryWriter(writer, opts)
+	a.commands = make(map[string]uint64)
+	return a, nil
+}
+
+func (a *ParseAof) Parse() error {
+	defer a.output.Close()
+	for _, file := range a.files {
+		if err := a.replayFile(file); err != nil {
+			return err
+		}
+	}
+	return a.flushWriter()
+}
+
+func (a *ParseAof) replayFile(file string) error {
+	handler, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+
+	err = a.replay(handler)
+	if parseErr, ok := err.(*ParseError); ok && a.multiPart {
+		parseErr.File = filepath.Base(file)
+		// As redis with aof-load-truncated, the truncated tail of the last incr file is discarded.
+		if file == a.lastIncr && errors.Is(err, io.ErrUnexpectedEOF) {
+			a.reportTruncated(handler, parseErr)
+			return nil
+		}
+	} else if err != nil && a.multiPart {
+		return fmt.Errorf("%s: %w", filepath.Base(file), err)
+	}
+	return err
+}
+
+// Report the truncated tail like redis-check-aof.
+func (a *ParseAof) reportTruncated(handler *os.File, err *ParseError) {
+	var size int64
+	if info, statErr := handler.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	println(fmt.Sprintf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, ok_up_to_line=%d, diff=%d", err.File, size, err.Offset, err.Line-1, size-err.Offset))
+	println(fmt.Sprintf("AOF %s has a truncated tail, the %d last bytes are discarded.\n", err.File, size-err.Offset))
+}
+
+// replay decodes the RDB preamble of the stream if any (aof-use-rdb-preamble),
+// then every command following it.
+func (a *ParseAof) replay(reader io.Reader) error {
+	input := bufio.NewReaderSize(reader, readerSize)
+	a.db = 0
+	var offset int64
+	if head, err := input.Peek(len(rdb.REDIS)); err == nil && string(head) == rdb.REDIS {
+		decoder := rdb.NewDecoder(input, preamble{writer: a.writer})
+		decoder.SkipZeroChecksum = a.skipZeroChecksum
+		if err := decoder.Decode(); err != nil {
+			return err
+		}
+		offset = decoder.Offset()
+	}
+
+	resp := newReader(input, offset)
+	for {
+		args, err := resp.ReadCommand()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := a.apply(args); err != nil {
+			return &ParseError{Offset: resp.start, Line: resp.first, Err: err}
+		}
+	}
+}
+
+func (a *ParseAof) apply(args [][]byte) error {
+	cmd := Command{DB: a.db, Args: args}
+	name := cmd.Name()
+	if name == "SELECT" {
+		if len(args) != 2 {
+			return errors.New("wrong number of arguments for SELECT")
+		}
+		db, err := strconv.ParseUint(string(args[1]), 10, 64)
+		if err != nil {
+			return errors.New("invalid DB index '" + string(args[1]) + "'")
+		}
+		a.db = db
+		cmd.DB = db
+	}
+	a.commands[name]++
+	a.total++
+	a.writer.Collect(cmd)
+	return nil
+}
+
+func (a *ParseAof) flushWriter() error {
+	if err := a.writer.FlushFile(); err != nil {
+		return err
+	}
+	a.writer.FlushGather()
+	a.flushCommands()
+	return nil
+}
+
+func (a *ParseAof) flushCommands() {
+	names := make([]string, 0, len(a.commands))
+	for name := range a.commands {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if a.commands[names[i]] != a.commands[names[j]] {
+			return a.commands[names[i]] > a.commands[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	println("\n-------- commands -------\n")
+	println(fmt.Sprintf("Replayed %d commands in the aof file!\n", a.total))
+	for _, name := range names {
+		println(fmt.Sprintf("%d %s", a.commands[name], name))
+	}
+}
+
+// preamble writes the records of the RDB preamble into the gen-file.
+type preamble struct {
+	writer *rdb.WriterRDB
+}
+
+func (p preamble) OnAux(aux rdb.AuxField) error {
+	p.writer.Collect(aux)
+	return nil
+}
+
+func (p preamble) OnSelectDB(db rdb.SelectionDB) error {
+	p.writer.Collect(db)
+	return nil
+}
+
+func (p preamble) OnResizeDB(resize rdb.ResizeDB) error {
+	p.writer.Collect(resize)
+	return nil
+}
+
+func (p preamble) OnString(s rdb.StringObject) error {
+	p.writer.Collect(s)
+	return nil
+}
+
+func (p preamble) OnList(l rdb.ListObject) error {
+	p.writer.Collect(l)
+	return nil
+}
+
+func (p preamble) OnSet(s rdb.Set) error {
+	p.writer.Collect(s)
+	return nil
+}
+
+func (p preamble) OnZSet(zs rdb.SortedSet) error {
+	p.writer.Collect(zs)
+	return nil
+}
+
+func (p preamble) OnHash(hm rdb.HashMap) error {
+	p.writer.Collect(hm)
+	return nil
+}
+
+func (p preamble) OnStream(rs rdb.RedisStream) error {
+	p.writer.Collect(rs)
+	return nil
+}
+
+func (p preamble) OnFunction(f rdb.FunctionLibrary) error {
+	p.writer.Collect(f)
+	return nil
+}
+
+func (p preamble) OnModule(m rdb.ModuleObject) error {
+	p.writer.Collect(m)
+	return nil
+}
+
+func (p preamble) OnModuleAux(aux rdb.ModuleAux) error {
+	p.writer.Collect(aux)
+	return nil
+}
+
+func (p preamble) OnEnd() error {
+	return nil
+}
@@ -0,0 +1,158 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Bulks longer than it are read without preallocation, a corrupt length
+// must not allocate more than the data really holds.
+const maxPreAlloc = 1 << 16
+
+// reader reads the RESP commands of an AOF stream.
+type reader struct {
+	input  *bufio.Reader
+	offset int64 // Offset in the stream of the next byte
+	start  int64 // Offset of the command being read
+	line   int64 // Lines read so far
+	first  int64 // Line where the command being read starts
+}
+
+func newReader(input *bufio.Reader, offset int64) *reader {
+	return &reader{input: input, offset: offset}
+}
+
+// ReadCommand returns the arguments of the next command, io.EOF at the end of the stream.
+// Errors of the stream are returned as *ParseError.
+func (r *reader) ReadCommand() ([][]byte, error) {
+	for {
+		r.start, r.first = r.offset, r.line+1
+		b, err := r.input.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		r.offset++
+		if b == '#' {
+			// Annotations, like the timestamp "#TS:<unix time>" of redis 7.0
+			if _, err := r.readLine(); err != nil {
+				return nil, r.wrapError(err)
+			}
+			continue
+		}
+		if b != '*' {
+			return nil, r.wrapError(errors.New("expected '*', got '" + string(b) + "'"))
+		}
+		args, err := r.readArgs()
+		if err != nil {
+			return nil, r.wrapError(err)
+		}
+		return args, nil
+	}
+}
+
+func (r *reader) wrapError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &ParseError{Offset: r.start, Line: r.first, Err: err}
+}
+
+func (r *reader) readArgs() ([][]byte, error) {
+	argc, err := r.readInt("multibulk length")
+	if err != nil {
+		return nil, err
+	}
+	if argc < 1 {
+		return nil, errors.New("invalid multibulk length " + strconv.Itoa(argc))
+	}
+	args := make([][]byte, 0, allocCap(argc))
+	for i := 0; i < argc; i++ {
+		b, err := r.input.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		r.offset++
+		if b != '$' {
+			return nil, errors.New("expected '$', got '" + string(b) + "'")
+		}
+		length, err := r.readInt("bulk length")
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, errors.New("invalid bulk length " + strconv.Itoa(length))
+		}
+		arg, err := r.readBulk(length)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (r *reader) readBulk(length int) ([]byte, error) {
+	var arg []byte
+	if length <= maxPreAlloc {
+		arg = make([]byte, length)
+		if _, err := io.ReadFull(r.input, arg); err != nil {
+			return nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r.input, int64(length)); err != nil {
+			return nil, err
+		}
+		arg = buf.Bytes()
+	}
+	r.offset += int64(length)
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(r.input, crlf); err != nil {
+		return nil, err
+	}
+	r.offset += 2
+	r.line++
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return nil, errors.New("bulk is not terminated by CRLF")
+	}
+	return arg, nil
+}
+
+func (r *reader) readInt(name string) (int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(line))
+	if err != nil {
+		return 0, errors.New("invalid " + name + " '" + string(line) + "'")
+	}
+	return n, nil
+}
+
+// Read a line terminated by CRLF, returns it without the terminator.
+func (r *reader) readLine() ([]byte, error) {
+	line, err := r.input.ReadSlice('\n')
+	r.offset += int64(len(line))
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errors.New("line too long")
+		}
+		return nil, err
+	}
+	r.line++
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errors.New("line is not terminated by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+func allocCap(length int) int {
+	if length > maxPreAlloc {
+		return maxPreAlloc
+	}
+	return length
+}
@@ -0,0 +1,153 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const setCommand = "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n" // 27 bytes, 7 lines
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		offset    int64 // Of the stream, after a preamble
+		want      [][]string
+		err       string // Part of the error, empty for none
+		errOffset int64
+		errLine   int64
+		truncated bool // The error is io.ErrUnexpectedEOF
+	}{
+		{name: "command", input: setCommand, want: [][]string{{"SET", "k", "v"}}},
+		{name: "commands", input: "*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n" + setCommand, want: [][]string{{"SELECT", "1"}, {"SET", "k", "v"}}},
+		{name: "annotation", input: "#TS:1700000000\r\n" + setCommand, want: [][]string{{"SET", "k", "v"}}},
+		{name: "binary bulk", input: "*2\r\n$4\r\nPING\r\n$4\r\na\r\nb\r\n", want: [][]string{{"PING", "a\r\nb"}}},
+		{name: "empty bulk", input: "*3\r\n$3\r\nSET\r\n$0\r\n\r\n$1\r\nv\r\n", want: [][]string{{"SET", "", "v"}}},
+		{name: "empty stream", input: "", want: nil},
+		{name: "truncated bulk", input: setCommand + "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nva",
+			want: [][]string{{"SET", "k", "v"}}, err: "unexpected EOF", errOffset: 27, errLine: 8, truncated: true},
+		{name: "truncated length", input: setCommand + "*3\r\n$3\r\nSE",
+			want: [][]string{{"SET", "k", "v"}}, err: "unexpected EOF", errOffset: 27, errLine: 8, truncated: true},
+		{name: "truncated multibulk length", input: setCommand + "*3",
+			want: [][]string{{"SET", "k", "v"}}, err: "unexpected EOF", errOffset: 27, errLine: 8, truncated: true},
+		{name: "truncated after a preamble", input: "*1\r\n$4\r\nPI", offset: 100,
+			err: "offset 100, line 1: unexpected EOF", errOffset: 100, errLine: 1, truncated: true},
+		{name: "no multibulk", input: setCommand + "+OK\r\n",
+			want: [][]string{{"SET", "k", "v"}}, err: "expected '*', got '+'", errOffset: 27, errLine: 8},
+		{name: "empty multibulk", input: "*0\r\n", err: "invalid multibulk length 0", errLine: 1},
+		{name: "invalid bulk length", input: "*1\r\n$x\r\n", err: "invalid bulk length 'x'", errLine: 1},
+		{name: "bulk without CRLF", input: "*1\r\n$1\r\nab\r\n", err: "bulk is not terminated by CRLF", errLine: 1},
+		{name: "line without CR", input: "*1\n", err: "line is not terminated by CRLF", errLine: 1},
+	}
+	for _, test := range tests {
+		r := newReader(bufio.NewReader(strings.NewReader(test.input)), test.offset)
+		var got [][]string
+		var err error
+		for {
+			var args [][]byte
+			if args, err = r.ReadCommand(); err != nil {
+				break
+			}
+			cmd := make([]string, 0, len(args))
+			for _, arg := range args {
+				cmd = append(cmd, string(arg))
+			}
+			got = append(got, cmd)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: commands are %q, want %q", test.name, got, test.want)
+		}
+		if test.err == "" {
+			if err != io.EOF {
+				t.Errorf("%s: error %v, want io.EOF", test.name, err)
+			}
+			continue
+		}
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want a ParseError with %q", test.name, err, test.err)
+			continue
+		}
+		if parseErr.Offset != test.errOffset || parseErr.Line != test.errLine {
+			t.Errorf("%s: error at offset %d, line %d, want %d, %d", test.name, parseErr.Offset, parseErr.Line, test.errOffset, test.errLine)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) != test.truncated {
+			t.Errorf("%s: error %v is truncated: %t, want %t", test.name, err, !test.truncated, test.truncated)
+		}
+	}
+}
+
+// writeMultiPart writes a multi part AOF of the incr files, in sequence, and returns its directory.
+func writeMultiPart(t *testing.T, incrs ...string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := ""
+	for i, incr := range incrs {
+		seq := strconv.Itoa(i + 1)
+		name := "appendonly.aof." + seq + ".incr.aof"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(incr), 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifest += "file " + name + " seq " + seq + " type i\n"
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// replayCommands replays the AOF into the commands gen-file and returns it.
+func replayCommands(t *testing.T, file string) (string, error) {
+	t.Helper()
+	output, err := ioutil.TempDir("", "aof-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(output)
+	parser, err := NewAOF(file, protocol.Options{Output: output, GenFileType: "commands", DB: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = parser.Parse()
+	commands, readErr := ioutil.ReadFile(filepath.Join(output, "parser.txt"))
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return string(commands), err
+}
+
+// Like redis with aof-load-truncated, the truncated tail of the last incr file is discarded.
+func TestReplayTruncatedIncr(t *testing.T) {
+	truncated := "*3\r\n$3\r\nSET\r\n$1\r\nt\r\n$5\r\nva"
+
+	dir := writeMultiPart(t, setCommand, "*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n"+truncated)
+	defer os.RemoveAll(dir)
+	commands, err := replayCommands(t, dir)
+	if err != nil {
+		t.Fatalf("truncated last incr file: %v", err)
+	}
+	if want := "SET k v\nDEL k\n"; commands != want {
+		t.Errorf("commands of the truncated last incr file are %q, want %q", commands, want)
+	}
+
+	// The other files must be complete.
+	dir = writeMultiPart(t, setCommand+truncated, setCommand)
+	defer os.RemoveAll(dir)
+	_, err = replayCommands(t, dir)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != "appendonly.aof.1.incr.aof" || parseErr.Offset != 27 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated first incr file: error %v, want a ParseError of appendonly.aof.1.incr.aof at offset 27", err)
+	}
+}
@@ -1,7 +1,9 @@
 package boot
 
 import (
+	"errors"
 	"fmt"
+	"github.com/8090Lambert/go-redis-parser/aof"
 	"github.com/8090Lambert/go-redis-parser/command"
 	"github.com/8090Lambert/go-redis-parser/constants"
 	"github.com/8090Lambert/go-redis-parser/protocol"
@@ -11,33 +13,72 @@ import (
 )
 
 func Boot() {
-	mod, file := command.Watch()
+	mod, file, err := command.Watch()
+	if err != nil {
+		exit(err, 2)
+	}
 	if mod == constants.UNKNOWN || file == "" {
-		return
+		os.Exit(2)
+	}
+
+	if err := run(mod, file); err != nil {
+		exit(err, 1)
 	}
+}
+
+func run(mod int, file string) error {
 	if _, err := os.Stat(file); err != nil && os.IsNotExist(err) {
-		panic(file + " not exist !")
+		return errors.New(file + " not exist !")
 	}
 
 	factory := NewParserFactory(mod)
 	if factory == nil {
-		return
+		return errors.New("unsupported parse mode")
 	}
 
-	defer func() {
-		if err := recover(); err != nil {
-			if _, ok := err.(string); ok {
-				fmt.Println(color.RedString(fmt.Sprintf("Parse failed: %s", err)))
-			}
-		}
-	}()
+	parser, err := factory(file, protocol.Options{
+		Output:           command.Output,
+		GenFileType:      command.GenFileType,
+		SkipZeroChecksum: command.SkipZeroChecksum,
+		DumpFunctions:    command.DumpFunctions,
+		Batch:            command.Batch,
+		KeysOnly:         command.KeysOnly,
+		Top:              command.Top,
+		PrefixDepth:      command.PrefixDepth,
+		PrefixDelimiters: command.PrefixDelimiters,
+		PrefixTop:        command.PrefixTop,
+		Patterns:         command.Patterns,
+		KeyPattern:       command.KeyPattern,
+		KeyRegex:         command.KeyRegex,
+		DB:               command.DB,
+		KeyType:          command.KeyType,
+		MinSize:          command.MinSize,
+		MaxSize:          command.MaxSize,
+		MinLen:           command.MinLen,
+		Expiring:         command.Expiring,
+		Persistent:       command.Persistent,
+		ExpiredBefore:    command.ExpiredBefore,
+	})
+	if err != nil {
+		return err
+	}
+	return parser.Parse()
+}
 
-	factory(file).Parse()
+func exit(err error, code int) {
+	fmt.Fprintln(os.Stderr, color.RedString(fmt.Sprintf("Parse failed: %s", err)))
+	os.Exit(code)
 }
 
 func NewParserFactory(mod int) protocol.Factory {
 	if mod == constants.RDBMOD {
 		return rdb.NewRDB
+	} else if mod == constants.AOFMOD {
+		return aof.NewAOF
+	} else if mod == constants.VERIFYMOD {
+		return rdb.NewVerifier
+	} else if mod == constants.REWRITEMOD {
+		return rdb.NewRewriter
 	} else {
 		return nil
 	}
@@ -1,45 +1,127 @@
 package command
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/8090Lambert/go-redis-parser/constants"
 	"github.com/fatih/color"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
 )
 
 var (
-	Output      string
-	GenFileType string
+	Output           string
+	GenFileType      string
+	SkipZeroChecksum bool
+	DumpFunctions    string
+	Batch            int
+	KeysOnly         bool
+	Top              int
+	PrefixDepth      int
+	PrefixDelimiters string
+	PrefixTop        int
+	Patterns         int
+	KeyPattern       string
+	KeyRegex         string
+	DB               = -1
+	KeyType          string
+	MinSize          uint64
+	MaxSize          uint64
+	MinLen           uint64
+	Expiring         bool
+	Persistent       bool
+	ExpiredBefore    time.Time
 )
 
+var genFileTypes = map[string]struct{}{"csv": {}, "json": {}, "ndjson": {}, "resp": {}, "commands": {}, "memory": {}}
+
+var keyTypes = map[string]struct{}{"string": {}, "list": {}, "set": {}, "zset": {}, "hash": {}, "stream": {}, "module": {}}
+
 var (
-	rdbFile string
-	aofFile string
+	rdbFile       string
+	aofFile       string
+	verify        bool
+	expiredBefore string
 )
 
 func Start() {
-	//flag.StringVar(&aofFile, "aof", "", "file.aof. For example: ./appendonly.aof\n")
+	flag.StringVar(&aofFile, "aof", "", "<aof-file-name>, or the manifest (or its directory) of a multi part aof. For example: ./appendonly.aof\n")
 	flag.StringVar(&rdbFile, "rdb", "", "<rdb-file-name>. For example: ./dump.rdb\n")
-	flag.StringVar(&Output, "o", "", "set the output directory for gen-file. (default: current directory. parser.(json|csv) will be created)\n")
-	flag.StringVar(&GenFileType, "type", "csv", "set the gen-file's type, support type: json、csv. (default: csv)\n")
+	flag.StringVar(&Output, "o", "", "set the output directory for gen-file. (default: current directory. parser.(json|ndjson|csv|resp|txt) will be created)\n")
+	flag.StringVar(&GenFileType, "type", "csv", "set the gen-file's type, support type: json、ndjson、csv、resp、commands、memory. (default: csv)\n")
+	flag.BoolVar(&verify, "verify", false, "only validate the rdb file and its CRC64 checksum, report OK or mismatch.\n")
+	flag.StringVar(&DumpFunctions, "dump-functions", "", "write every function library (redis 7.0+) into <dir>/<library>.lua\n")
+	flag.IntVar(&Batch, "batch", 1000, "items by command at most for the resp and commands gen-files, 0 for no limit.\n")
+	flag.BoolVar(&SkipZeroChecksum, "skip-zero-checksum", false, "accept a zero checksum, written by redis when rdbchecksum is disabled.\n")
+	flag.BoolVar(&KeysOnly, "keys-only", false, "skip the values, only write the database, key, type, expire and serialized size of the keys (csv, json, ndjson).\n")
+	flag.IntVar(&Top, "top", 0, "print the N biggest keys of every type by elements, bytes and memory, and write them into top.(csv|json). 0 for the biggest key only.\n")
+	flag.IntVar(&PrefixDepth, "prefix-depth", 0, "break down the keys by prefix of N segments at most, like svc:entity:, 0 for no breakdown.\n")
+	flag.StringVar(&PrefixDelimiters, "prefix-delimiters", ":", "characters ending the segments of the key prefixes.\n")
+	flag.IntVar(&PrefixTop, "prefix-top", 20, "prefixes of the top, and children by prefix of the tree.\n")
+	flag.IntVar(&Patterns, "patterns", 0, "infer the patterns of the keys, like user:{int}:profile, and print the N biggest. 0 for no inference.\n")
+	keyFilterFlags(flag.CommandLine)
+	flag.Uint64Var(&MinSize, "min-size", 0, "only the keys whose value has this size in bytes at least.\n")
+	flag.Uint64Var(&MaxSize, "max-size", 0, "only the keys whose value has this size in bytes at most, 0 for no limit.\n")
+	flag.Uint64Var(&MinLen, "min-len", 0, "only the keys whose value has this number of elements at least (bytes of a string, fields of a hash...).\n")
 
 	flag.Parse()
 	flag.Usage = defaultUsage
 }
 
-func Watch() (mod int, file string) {
+func Watch() (mod int, file string, err error) {
+	if len(os.Args) > 1 && os.Args[1] == "rewrite" {
+		return watchRewrite(os.Args[2:])
+	}
 	Start()
-	if GenFileType != "csv" && GenFileType != "json" {
-		return
+	if _, ok := genFileTypes[GenFileType]; !ok {
+		return constants.UNKNOWN, "", errors.New("unsupported gen-file type: " + GenFileType)
+	}
+	if Batch < 0 {
+		return constants.UNKNOWN, "", errors.New("-batch must not be negative")
+	}
+	if Top < 0 {
+		return constants.UNKNOWN, "", errors.New("-top must not be negative")
+	}
+	if PrefixDepth < 0 || PrefixTop < 1 {
+		return constants.UNKNOWN, "", errors.New("-prefix-depth must not be negative, -prefix-top must be positive")
+	}
+	if Patterns < 0 {
+		return constants.UNKNOWN, "", errors.New("-patterns must not be negative")
+	}
+	if PrefixDepth > 0 && PrefixDelimiters == "" {
+		return constants.UNKNOWN, "", errors.New("-prefix-delimiters must not be empty")
+	}
+	if err := checkKeyFilter(); err != nil {
+		return constants.UNKNOWN, "", err
 	}
-	if rdbFile != "" {
-		return constants.RDBMOD, rdbFile
+	if MaxSize > 0 && MinSize > MaxSize {
+		return constants.UNKNOWN, "", errors.New("-min-size is greater than -max-size")
+	}
+	if KeysOnly && (GenFileType == "resp" || GenFileType == "commands" || GenFileType == "memory") {
+		return constants.UNKNOWN, "", errors.New("-keys-only has no value for the " + GenFileType + " gen-file")
+	}
+	if KeysOnly && (MinSize > 0 || MaxSize > 0 || MinLen > 0) {
+		return constants.UNKNOWN, "", errors.New("-min-size, -max-size and -min-len need the values, not -keys-only")
+	}
+	if rdbFile != "" && verify {
+		return constants.VERIFYMOD, rdbFile, nil
+	} else if rdbFile != "" {
+		return constants.RDBMOD, rdbFile, nil
 	} else if aofFile != "" {
-		return constants.AOFMOD, aofFile
+		if hasKeyFilter() {
+			return constants.UNKNOWN, "", errors.New("the key filters only apply to -rdb")
+		}
+		if KeysOnly {
+			return constants.UNKNOWN, "", errors.New("-keys-only only applies to -rdb")
+		}
+		return constants.AOFMOD, aofFile, nil
 	} else {
 		flag.Usage()
-		return constants.UNKNOWN, ""
+		return constants.UNKNOWN, "", nil
 	}
 }
 
@@ -49,3 +131,83 @@ func defaultUsage() {
 	)
 	flag.PrintDefaults()
 }
+
+// rewrite -rdb <rdb-file-name> -o <rdb-file-name> [-key pattern] [-db index] [-key-type|-type type]
+func watchRewrite(args []string) (mod int, file string, err error) {
+	flags := flag.NewFlagSet("rewrite", flag.ExitOnError)
+	flags.StringVar(&rdbFile, "rdb", "", "<rdb-file-name> to rewrite. For example: ./dump.rdb\n")
+	flags.StringVar(&Output, "o", "", "<rdb-file-name> of the new dump, keeping only the matching keys. For example: ./slice.rdb\n")
+	keyFilterFlags(flags)
+	// No gen-file here, -type is the key type like in the first release of the subcommand.
+	flags.StringVar(&KeyType, "type", "", "alias of -key-type.\n")
+	flags.BoolVar(&SkipZeroChecksum, "skip-zero-checksum", false, "accept a zero checksum, written by redis when rdbchecksum is disabled.\n")
+	flags.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr, fmt.Sprintf(usageformat, logo, color.GreenString(app), color.YellowString(version), releaseTime),
+		)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if rdbFile == "" || Output == "" {
+		flags.Usage()
+		return constants.UNKNOWN, "", nil
+	}
+	if err := checkKeyFilter(); err != nil {
+		return constants.UNKNOWN, "", err
+	}
+	if in, out := absPath(rdbFile), absPath(Output); in == out {
+		return constants.UNKNOWN, "", errors.New("the new dump must not overwrite " + rdbFile)
+	}
+	return constants.REWRITEMOD, rdbFile, nil
+}
+
+func absPath(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+// Filters of the keys known before their values, shared by the parse and the rewrite.
+func keyFilterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&KeyPattern, "key", "", "only the keys matching the glob pattern, like the KEYS command. For example: 'user:*'\n")
+	flags.StringVar(&KeyRegex, "key-regex", "", "only the keys matching the regular expression. For example: '^user:[0-9]+$'\n")
+	flags.IntVar(&DB, "db", -1, "only the keys of the database index, -1 for every database.\n")
+	flags.StringVar(&KeyType, "key-type", "", "only the keys of the type, support type: string、list、set、zset、hash、stream、module. (default: every type)\n")
+	flags.BoolVar(&Expiring, "expiring", false, "only the keys with an expire.\n")
+	flags.BoolVar(&Persistent, "persistent", false, "only the keys without expire.\n")
+	flags.StringVar(&expiredBefore, "expired-before", "", "only the keys expiring before the time, RFC3339 or unix timestamp. For example: 2024-01-01T00:00:00Z\n")
+}
+
+func checkKeyFilter() error {
+	if KeyType != "" {
+		if _, ok := keyTypes[KeyType]; !ok {
+			return errors.New("unsupported key type: " + KeyType)
+		}
+	}
+	if KeyRegex != "" {
+		if _, err := regexp.Compile(KeyRegex); err != nil {
+			return errors.New("invalid -key-regex: " + err.Error())
+		}
+	}
+	if expiredBefore != "" {
+		if unix, err := strconv.ParseInt(expiredBefore, 10, 64); err == nil {
+			ExpiredBefore = time.Unix(unix, 0)
+		} else if ExpiredBefore, err = time.Parse(time.RFC3339, expiredBefore); err != nil {
+			return errors.New("invalid -expired-before, RFC3339 or unix timestamp expected: " + expiredBefore)
+		}
+	}
+	if Expiring && Persistent {
+		return errors.New("-expiring and -persistent are exclusive")
+	}
+	if Persistent && expiredBefore != "" {
+		return errors.New("-persistent and -expired-before are exclusive")
+	}
+	return nil
+}
+
+func hasKeyFilter() bool {
+	return KeyPattern != "" || KeyRegex != "" || DB >= 0 || KeyType != "" || MinSize > 0 || MaxSize > 0 || MinLen > 0 ||
+		Expiring || Persistent || expiredBefore != ""
+}
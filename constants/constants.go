@@ -1,7 +1,9 @@
 package constants
 
 const (
-	UNKNOWN = 0
-	RDBMOD  = 1
-	AOFMOD  = 2
+	UNKNOWN    = 0
+	RDBMOD     = 1
+	AOFMOD     = 2
+	VERIFYMOD  = 3
+	REWRITEMOD = 4
 )
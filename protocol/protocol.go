@@ -1,5 +1,7 @@
 package protocol
 
+import "time"
+
 var (
 	Aux       = "AuxField" // 辅助数据
 	SelectDB  = "SelectDB"
@@ -11,10 +13,14 @@ var (
 	SortedSet = "SortedSet"
 	List      = "List"
 	Stream    = "Stream"
+	Function  = "Function" // Redis Functions library
+	Module    = "Module"
+	ModuleAux = "ModuleAux" // Auxiliary data of a module
+	Command   = "Command"   // Command of an AOF file
 )
 
 type Parser interface {
-	Parse()
+	Parse() error
 }
 
 type TypeObject interface {
@@ -26,4 +32,37 @@ type TypeObject interface {
 	ConcreteSize() uint64 // Data bytes size, except metadata
 }
 
-type Factory func(file string) Parser
+// Options of the command line, shared by all kinds of parser.
+type Options struct {
+	Output      string // Directory of the gen-file
+	GenFileType string // Type of the gen-file: csv, json, ndjson, resp, commands or memory
+
+	SkipZeroChecksum bool   // Accept a zero checksum, written when rdbchecksum is disabled
+	DumpFunctions    string // Directory to write every function library into its own file
+
+	Batch int // Items by command at most of the resp and commands gen-files, 0 for no limit
+
+	KeysOnly bool // Skip the values, only the database, key, type, expiry and serialized size are written
+	Top      int  // Biggest keys of every type in the summary and the top file, 0 for the biggest key only
+
+	// Prefix analysis of the keys
+	PrefixDepth      int    // Segments of the prefixes at most, 0 for no analysis
+	PrefixDelimiters string // Characters ending the segments of the keys
+	PrefixTop        int    // Prefixes of the top, and children by prefix of the tree
+
+	Patterns int // Inferred patterns of the keys in the summary, 0 for no inference
+
+	// Filters of the keys
+	KeyPattern    string    // Glob pattern of the keys, like the KEYS command
+	KeyRegex      string    // Regular expression of the keys
+	DB            int       // Database of the keys, -1 for every database
+	KeyType       string    // Type of the keys: string, list, set, zset, hash, stream or module
+	MinSize       uint64    // Size of the values in bytes at least
+	MaxSize       uint64    // Size of the values in bytes at most, 0 for no limit
+	MinLen        uint64    // Elements of the values at least
+	Expiring      bool      // Only the keys with an expire
+	Persistent    bool      // Only the keys without expire
+	ExpiredBefore time.Time // Only the keys expiring before
+}
+
+type Factory func(file string, opts Options) (Parser, error)
@@ -10,12 +10,6 @@ type AuxField struct {
 	Val   interface{}
 }
 
-func (r *ParseRdb) AuxFields(key, val []byte) AuxField {
-	aux := AuxField{Field: key, Val: val}
-	r.d2 <- aux
-	return aux
-}
-
 func (af AuxField) String() string {
 	return fmt.Sprintf("{Aux: {Key: %s, Value: %s}}", ToString(af.Field), ToString(af.Val))
 }
@@ -0,0 +1,233 @@
+package rdb
+
+import (
+	"bufio"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Default items of a collection written by command at most, a huge key is restored by several commands.
+const commandBatch = 1000
+
+// commandWriter converts every record into the commands restoring it, SELECT
+// when the database changes, one command or more by key followed by PEXPIREAT.
+type commandWriter struct {
+	handler *bufio.Writer
+	encode  func(w *bufio.Writer, args []string) // Encoding of a command
+	batch   int
+}
+
+// CommandRecord is a record which is a command itself, like the commands of an AOF file.
+type CommandRecord interface {
+	Command() []string
+}
+
+func newCommandWriter(writer io.Writer, encode func(w *bufio.Writer, args []string), batch int) *commandWriter {
+	return &commandWriter{handler: bufio.NewWriter(writer), encode: encode, batch: batch}
+}
+
+func (c *commandWriter) Write(entity protocol.TypeObject, memory uint64) error {
+	for _, args := range c.commands(entity) {
+		c.encode(c.handler, args)
+	}
+	return nil
+}
+
+func (c *commandWriter) Flush() error {
+	return c.handler.Flush()
+}
+
+func (c *commandWriter) commands(entity protocol.TypeObject) [][]string {
+	switch v := entity.(type) {
+	case SelectionDB:
+		return [][]string{{"SELECT", strconv.FormatUint(v.Index, 10)}}
+	case FunctionLibrary:
+		return [][]string{{"FUNCTION", "LOAD", v.Code}}
+	case CommandRecord:
+		return [][]string{v.Command()}
+	case KeyRecord:
+		cmds := keyCommands(v, c.batch)
+		if meta := v.Meta(); len(cmds) > 0 && meta.HasExpire() {
+			cmds = append(cmds, []string{"PEXPIREAT", v.Key(), strconv.FormatInt(meta.ExpireMs(), 10)})
+		}
+		return cmds
+	}
+	return nil
+}
+
+// keyCommands returns the commands creating the key, collections are split
+// into commands of batch items at most. Modules have no command.
+func keyCommands(entity KeyRecord, batch int) [][]string {
+	key := entity.Key()
+	switch v := entity.(type) {
+	case StringObject:
+		return [][]string{{"SET", key, v.Value()}}
+	case ListObject:
+		return batchCommands([]string{"RPUSH", key}, v.Entries, 1, batch)
+	case Set:
+		return batchCommands([]string{"SADD", key}, v.Entries, 1, batch)
+	case SortedSet:
+		args := make([]string, 0, len(v.Entries)*2)
+		for _, entry := range v.Entries {
+			args = append(args, formatScore(entry.Score), ToString(entry.Field))
+		}
+		return batchCommands([]string{"ZADD", key}, args, 2, batch)
+	case HashMap:
+		args := make([]string, 0, len(v.Entry)*2)
+		for _, entry := range v.Entry {
+			args = append(args, entry.Field, entry.Value)
+		}
+		return batchCommands([]string{"HSET", key}, args, 2, batch)
+	case RedisStream:
+		return streamCommands(key, v)
+	}
+	return nil
+}
+
+// Split the items into commands of batch elements at most, an element is
+// made of size items, for example field and value of a hash.
+func batchCommands(prefix []string, items []string, size, batch int) [][]string {
+	if len(items) == 0 {
+		return nil
+	}
+	step := len(items)
+	if batch > 0 && batch*size < step {
+		step = batch * size
+	}
+	cmds := make([][]string, 0, (len(items)+step-1)/step)
+	for i := 0; i < len(items); i += step {
+		end := i + step
+		if end > len(items) {
+			end = len(items)
+		}
+		args := make([]string, 0, len(prefix)+end-i)
+		args = append(append(args, prefix...), items[i:end]...)
+		cmds = append(cmds, args)
+	}
+	return cmds
+}
+
+// A stream is restored by XADD of every entry, XSETID of its last id, then
+// XGROUP CREATE and XCLAIM of the pending entries for every consumer group.
+func streamCommands(key string, rs RedisStream) [][]string {
+	var cmds [][]string
+	for _, message := range rs.Messages() {
+		if message.Deleted {
+			continue
+		}
+		args := []string{"XADD", key, message.Id.String()}
+		for _, field := range message.Fields {
+			args = append(args, field.Field, field.Value)
+		}
+		cmds = append(cmds, args)
+	}
+	if len(cmds) == 0 && rs.LastId != (StreamId{}) {
+		// Every entry is deleted, create the stream empty.
+		cmds = append(cmds, []string{"XADD", key, "MAXLEN", "0", rs.LastId.String(), "_", "_"})
+	}
+	if len(cmds) > 0 {
+		setId := []string{"XSETID", key, rs.LastId.String()}
+		if rs.EntriesAdded > 0 {
+			setId = append(setId, "ENTRIESADDED", strconv.FormatUint(rs.EntriesAdded, 10), "MAXDELETEDID", rs.MaxDeletedId.String())
+		}
+		cmds = append(cmds, setId)
+	}
+	for _, group := range rs.Groups {
+		create := []string{"XGROUP", "CREATE", key, group.Name, group.LastId, "MKSTREAM"}
+		if rs.EntriesAdded > 0 {
+			create = append(create, "ENTRIESREAD", strconv.FormatUint(group.EntriesRead, 10))
+		}
+		cmds = append(cmds, create)
+		for _, consumer := range group.Consumers {
+			if len(consumer.PendingEntryList) == 0 {
+				cmds = append(cmds, []string{"XGROUP", "CREATECONSUMER", key, group.Name, consumer.Name})
+			}
+		}
+		for _, pending := range group.Pending() {
+			if pending.Consumer == "" {
+				continue
+			}
+			cmds = append(cmds, []string{
+				"XCLAIM", key, group.Name, pending.Consumer, "0", pending.Id.String(),
+				"TIME", strconv.FormatUint(pending.DeliveryTime, 10),
+				"RETRYCOUNT", strconv.FormatUint(pending.DeliveryCount, 10),
+				"FORCE", "JUSTID",
+			})
+		}
+	}
+	return cmds
+}
+
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "+inf"
+	} else if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// RESP multi bulk, as redis-cli --pipe expects it.
+func writeRESP(w *bufio.Writer, args []string) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(len(args)))
+	w.WriteString("\r\n")
+	for _, arg := range args {
+		w.WriteByte('$')
+		w.WriteString(strconv.Itoa(len(arg)))
+		w.WriteString("\r\n")
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+// Inline command by line, arguments are quoted when needed the way redis-cli
+// reads them: SET "key with space" "\x00\x01"
+func writeInline(w *bufio.Writer, args []string) {
+	for i, arg := range args {
+		if i > 0 {
+			w.WriteByte(' ')
+		}
+		w.WriteString(quoteInline(arg))
+	}
+	w.WriteByte('\n')
+}
+
+func quoteInline(arg string) string {
+	plain := arg != ""
+	for i := 0; i < len(arg) && plain; i++ {
+		plain = arg[i] > ' ' && arg[i] < 0x7f && arg[i] != '"' && arg[i] != '\'' && arg[i] != '\\'
+	}
+	if plain {
+		return arg
+	}
+	quoted := make([]byte, 0, len(arg)+2)
+	quoted = append(quoted, '"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\', '"':
+			quoted = append(quoted, '\\', c)
+		case '\n':
+			quoted = append(quoted, '\\', 'n')
+		case '\r':
+			quoted = append(quoted, '\\', 'r')
+		case '\t':
+			quoted = append(quoted, '\\', 't')
+		case '\a':
+			quoted = append(quoted, '\\', 'a')
+		case '\b':
+			quoted = append(quoted, '\\', 'b')
+		default:
+			if c < ' ' || c >= 0x7f {
+				quoted = append(quoted, '\\', 'x', hexDigits[c>>4], hexDigits[c&0x0f])
+			} else {
+				quoted = append(quoted, c)
+			}
+		}
+	}
+	return string(append(quoted, '"'))
+}
+
+const hexDigits = "0123456789abcdef"
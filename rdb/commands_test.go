@@ -0,0 +1,58 @@
+package rdb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// streamRecorder keeps the decoded streams by name.
+type streamRecorder struct {
+	NopHandler
+	streams map[string]RedisStream
+}
+
+func (r *streamRecorder) OnStream(rs RedisStream) error {
+	r.streams[rs.Key()] = rs
+	return nil
+}
+
+func decodeStreams(t *testing.T, dump []byte) map[string]RedisStream {
+	t.Helper()
+	r := &streamRecorder{streams: make(map[string]RedisStream)}
+	if err := NewDecoder(bytes.NewReader(dump), r).Decode(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return r.streams
+}
+
+// The entries are added with their fields in the order of the dump, a repeated field included.
+func TestStreamCommandsFieldOrder(t *testing.T) {
+	tests := []struct {
+		fixture string
+		key     string
+		xadd    [][]string
+	}{
+		{"dump-stream.rdb", "test", [][]string{{"XADD", "test", "1528468399779-0", "k", "v", "k", "v"}}},
+		{"dumpV9.rdb", "stream", [][]string{
+			{"XADD", "stream", "1569553992318-0", "name", "Lambert", "age", "29"},
+			{"XADD", "stream", "1569554028209-0", "name", "Jack", "age", "26"},
+			{"XADD", "stream", "1569554036762-0", "name", "Tom", "age", "30"},
+		}},
+	}
+	for _, test := range tests {
+		rs, ok := decodeStreams(t, readFixture(t, test.fixture))[test.key]
+		if !ok {
+			t.Fatalf("%s: no stream %s", test.fixture, test.key)
+		}
+		var xadd [][]string
+		for _, cmd := range streamCommands(test.key, rs) {
+			if cmd[0] == "XADD" {
+				xadd = append(xadd, cmd)
+			}
+		}
+		if !reflect.DeepEqual(xadd, test.xadd) {
+			t.Errorf("%s: %s is added by %q, want %q", test.fixture, test.key, xadd, test.xadd)
+		}
+	}
+}
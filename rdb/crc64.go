@@ -0,0 +1,16 @@
+package rdb
+
+import (
+	"hash/crc64"
+)
+
+// Redis checksums the RDB with crc64 Jones (reflected, no initial value and no final xor),
+// hash/crc64 only supplies the table of the polynomial 0xad93d23594c935a9.
+var crc64Table = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -14,12 +14,6 @@ type ResizeDB struct {
 	ExpireSize uint64
 }
 
-func (r *ParseRdb) Resize(dbSize, expireSize uint64) ResizeDB {
-	resize := ResizeDB{DBSize: dbSize, ExpireSize: expireSize}
-	r.d2 <- resize
-	return resize
-}
-
 func (r ResizeDB) String() string {
 	return fmt.Sprintf("{ResizeDB: %s}", r.Value())
 }
@@ -44,12 +38,6 @@ func (r ResizeDB) Type() string {
 	return protocol.ResizeDB
 }
 
-func (r *ParseRdb) Selection(index uint64) SelectionDB {
-	selectDB := SelectionDB{Index: index}
-	r.d2 <- selectDB
-	return selectDB
-}
-
 func (s SelectionDB) Type() string {
 	return protocol.SelectDB
 }
@@ -0,0 +1,641 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	// Redis Object type
+	TypeString = iota
+	TypeList
+	TypeSet
+	TypeZset
+	TypeHash
+	TypeZset2   /* ZSET version 2 with doubles stored in binary. */
+	TypeModule  // Module value saved without opcodes, only loadable with a registered decoder.
+	TypeModule2 // Module value with opcodes, see RegisterModule.
+	_
+	TypeHashZipMap
+	TypeListZipList
+	TypeSetIntSet
+	TypeZsetZipList
+	TypeHashZipList
+	TypeListQuickList
+	TypeStreamListPacks
+	TypeHashListPack
+	TypeZsetListPack
+	TypeListQuickList2 // quicklist with plain and packed (listpack) nodes
+	TypeStreamListPacks2
+	TypeSetListPack
+	TypeStreamListPacks3
+
+	// Redis RDB protocol
+	FlagOpcodeSlotInfo     = 244 /* Cluster slot info, since RDB 12. */
+	FlagOpcodeFunction2    = 245 /* Function library data. */
+	FlagOpcodeFunction     = 246 /* Old function library data, saved by 7.0 release candidates. */
+	FlagOpcodeModuleAux    = 247 /* Module auxiliary data. */
+	FlagOpcodeIdle         = 248 /* LRU idle time. */
+	FlagOpcodeFreq         = 249 /* LFU frequency. */
+	FlagOpcodeAux          = 250 /* RDB aux field. */
+	FlagOpcodeResizeDB     = 251 /* Hash table resize hint. */
+	FlagOpcodeExpireTimeMs = 252 /* Expire time in milliseconds. */
+	FlagOpcodeExpireTime   = 253 /* Old expire time in seconds. */
+	FlagOpcodeSelectDB     = 254 /* DB number of the following keys. */
+	FlagOpcodeEOF          = 255
+
+	// Redis length type
+	Type6Bit   = 0
+	Type14Bit  = 1
+	Type32Bit  = 0x80
+	Type64Bit  = 0x81
+	TypeEncVal = 3
+
+	// Redis ziplist types
+	ZipStr06B = 0
+	ZipStr14B = 1
+	ZipStr32B = 2
+
+	// Redis ziplist entry
+	ZipInt04B = 15
+	ZipInt08B = 0xfe        // 11111110
+	ZipInt16B = 0xc0 | 0<<4 // 11000000
+	ZipInt24B = 0xc0 | 3<<4 // 11110000
+	ZipInt32B = 0xc0 | 1<<4 // 11010000
+	ZipInt64B = 0xc0 | 2<<4 //11100000
+
+	ZipBigPrevLen = 0xfe
+
+	// Redis quicklist node container
+	QuickListNodePlain  = 1
+	QuickListNodePacked = 2
+
+	// Redis listpack
+	StreamItemFlagNone       = 0      /* No special flags. */
+	StreamItemFlagDeleted    = 1 << 0 /* Entry was deleted. Skip it. */
+	StreamItemFlagSameFields = 1 << 1 /* Same fields as master entry. */
+)
+
+const (
+	EncodeInt8 = iota
+	EncodeInt16
+	EncodeInt32
+	EncodeLZF
+
+	REDIS      = "REDIS"
+	VersionMin = 1
+	VersionMax = 12
+
+	// Since RDB version 5, a CRC64 of the whole stream follows the EOF opcode.
+	VersionChecksum = 5
+)
+
+var (
+	PosInf = math.Inf(1)
+	NegInf = math.Inf(-1)
+	Nan    = math.NaN()
+)
+
+// Decoder reads a RDB stream and reports every record it finds to a Handler.
+// It never touches the filesystem, so any io.Reader can be decoded.
+type Decoder struct {
+	// Redis writes a zero checksum when rdbchecksum is disabled, skip the verification of it.
+	SkipZeroChecksum bool
+	// Values of modules without a registered decoder are skipped instead of being reported as hex dump.
+	SkipUnknownModules bool
+	// Filter is called with every key before its value, the values of the keys it rejects are skipped without decoding.
+	Filter func(key KeyInfo) bool
+	// KeysOnly skips every value, the keys are reported to KeyHandler.OnKey with the size of their serialized value.
+	KeysOnly bool
+
+	input    *offsetReader
+	handler  Handler
+	raw      RawHandler // Handler of the serialized keys, nil to decode them
+	buff     []byte
+	version  int
+	checksum uint64
+	db       uint64 // Current database index
+	offset   int64  // Offset of the record being decoded
+	key      []byte // Key of the record being decoded
+	t        byte   // Type byte of the record being decoded
+}
+
+// NewDecoder decodes the stream into the handler, the keys are reported
+// serialized when the handler is a RawHandler.
+func NewDecoder(reader io.Reader, handler Handler) *Decoder {
+	raw, _ := handler.(RawHandler)
+	return &Decoder{
+		input:   newOffsetReader(bufio.NewReader(reader)),
+		handler: handler,
+		raw:     raw,
+		buff:    make([]byte, 8),
+	}
+}
+
+// Decode reads the whole stream, Handler.OnEnd is called once the EOF opcode is reached.
+// Errors of the stream are returned as *ParseError.
+func (d *Decoder) Decode() error {
+	if _, ok := d.handler.(KeyHandler); d.KeysOnly && !ok {
+		return errors.New("KeysOnly needs a KeyHandler")
+	}
+	if err := d.layoutCheck(); err != nil {
+		return d.wrapError(err)
+	}
+	if err := d.start(); err != nil {
+		return d.wrapError(err)
+	}
+	return d.handler.OnEnd()
+}
+
+func (d *Decoder) wrapError(err error) error {
+	if _, ok := err.(*ParseError); ok {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &ParseError{Offset: d.offset, DB: d.db, Key: string(d.key), Type: d.t, Err: err}
+}
+
+// 9 bytes length include: 5 bytes "REDIS" and 4 bytes version in rdb.file
+func (d *Decoder) layoutCheck() error {
+	header := make([]byte, 9)
+	_, err := io.ReadFull(d.input, header)
+	if err != nil {
+		if err == io.EOF {
+			return errors.New("RDB file is empty")
+		}
+		return errors.New("Read RDB file failed, error: " + err.Error())
+	}
+
+	// Check "REDIS" string and version.
+	rdbVersion, err := strconv.Atoi(string(header[5:]))
+	if !bytes.Equal(header[0:5], []byte(REDIS)) || err != nil || (rdbVersion < VersionMin || rdbVersion > VersionMax) {
+		return errors.New("RDB file version is wrong")
+	}
+	d.version = rdbVersion
+
+	return nil
+}
+
+// Compare the CRC64 following the EOF opcode with the one of the stream.
+func (d *Decoder) verifyChecksum() error {
+	if d.version < VersionChecksum {
+		return nil
+	}
+	actual := d.input.Crc64()
+	if _, err := io.ReadFull(d.input, d.buff); err != nil {
+		return errors.New("Parse checksum failed: " + err.Error())
+	}
+	d.checksum = binary.LittleEndian.Uint64(d.buff)
+	if d.checksum == 0 && d.SkipZeroChecksum {
+		return nil
+	}
+	if d.checksum != actual {
+		return &ChecksumError{Expected: d.checksum, Actual: actual}
+	}
+	return nil
+}
+
+// Checksum returns the CRC64 stored at the end of the stream, zero if the
+// stream has none. It is only meaningful after Decode.
+func (d *Decoder) Checksum() uint64 {
+	return d.checksum
+}
+
+// Offset returns the number of bytes read from the stream.
+func (d *Decoder) Offset() int64 {
+	return d.input.Offset()
+}
+
+// Version returns the RDB version of the stream, read by Decode.
+func (d *Decoder) Version() int {
+	return d.version
+}
+
+func (d *Decoder) start() error {
+	var expire, lruIdle, lfuFreq int64 = NoExpire, -1, -1
+	var hasSelectDb bool
+	var prefixed bool // Expire, idle and freq opcodes belong to the following key
+	for {
+		// Begin analyze
+		if !prefixed {
+			d.offset = d.input.Offset()
+			if d.raw != nil {
+				// The record is captured from its first opcode, in case it is a key.
+				d.input.startCapture()
+			}
+		}
+		d.key = nil
+		t, err := d.input.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("unexpected end of file, EOF opcode not found")
+			}
+			return err
+		}
+		d.t = t
+		prefixed = t == FlagOpcodeIdle || t == FlagOpcodeFreq || t == FlagOpcodeExpireTimeMs || t == FlagOpcodeExpireTime
+		if t == FlagOpcodeIdle {
+			b, _, err := d.loadLen()
+			if err != nil {
+				return errors.New("Parse LRU idle failed: " + err.Error())
+			}
+			lruIdle = int64(b)
+			continue
+		} else if t == FlagOpcodeFreq {
+			b, err := d.input.ReadByte()
+			if err != nil {
+				return errors.New("Parse LFU freq failed: " + err.Error())
+			}
+			lfuFreq = int64(b)
+			continue
+		} else if t == FlagOpcodeAux {
+			// RDB 7 版本之后引入
+			// redis-ver：版本号
+			// redis-bits：OS Arch
+			// ctime：RDB文件创建时间
+			// used-mem：使用内存大小
+			// repl-stream-db：在server.master客户端中选择的数据库
+			// repl-id：当前实例 replication ID
+			// repl-offset：当前实例复制的偏移量
+			// lua：lua脚本
+			key, err := d.loadString()
+			if err != nil {
+				return errors.New("Parse Aux key failed: " + err.Error())
+			}
+			val, err := d.loadString()
+			if err != nil {
+				return errors.New("Parse Aux value failed: " + err.Error())
+			}
+			if err := d.handler.OnAux(AuxField{Field: key, Val: val}); err != nil {
+				return err
+			}
+			continue
+		} else if t == FlagOpcodeResizeDB {
+			// RDB 7 版本之后引入，详见 https://github.com/antirez/redis/pull/5039/commits/5cd3c9529df93b7e726256e2de17985a57f00e7b
+			// 包含两个编码后的值，用于加速RDB的加载，避免在加载过程中额外的调整hash空间(resize)和rehash操作
+			// 1.数据库的哈希表大小
+			// 2.失效哈希表的大小
+			dbSize, _, err := d.loadLen()
+			if err != nil {
+				return errors.New("Parse ResizeDB size failed: " + err.Error())
+			}
+			expiresSize, _, err := d.loadLen()
+			if err != nil {
+				return errors.New("Parse ResizeDB size failed: " + err.Error())
+			}
+			if err := d.handler.OnResizeDB(ResizeDB{DBSize: dbSize, ExpireSize: expiresSize}); err != nil {
+				return err
+			}
+			continue
+		} else if t == FlagOpcodeExpireTimeMs {
+			_, err := io.ReadFull(d.input, d.buff)
+			if err != nil {
+				return errors.New("Parse ExpireTime_ms failed: " + err.Error())
+			}
+			expire = int64(binary.LittleEndian.Uint64(d.buff))
+			continue
+		} else if t == FlagOpcodeExpireTime {
+			_, err := io.ReadFull(d.input, d.buff[:4])
+			if err != nil {
+				return errors.New("Parse ExpireTime failed: " + err.Error())
+			}
+			// Seconds of a 32 bits signed integer, before RDB 3.
+			expire = int64(int32(binary.LittleEndian.Uint32(d.buff[:4]))) * 1000
+			continue
+		} else if t == FlagOpcodeSelectDB {
+			if hasSelectDb == true {
+				continue
+			}
+			dbindex, _, err := d.loadLen()
+			if err != nil {
+				return errors.New("Parse SelectDB index failed: " + err.Error())
+			}
+			d.db = dbindex
+			if err := d.handler.OnSelectDB(SelectionDB{Index: dbindex}); err != nil {
+				return err
+			}
+			hasSelectDb = false
+			continue
+		} else if t == FlagOpcodeFunction2 {
+			if err := d.readFunction(); err != nil {
+				return errors.New("Parse function failed: " + err.Error())
+			}
+			continue
+		} else if t == FlagOpcodeFunction {
+			if err := d.readFunctionPreGA(); err != nil {
+				return errors.New("Parse function failed: " + err.Error())
+			}
+			continue
+		} else if t == FlagOpcodeModuleAux {
+			if err := d.readModuleAux(); err != nil {
+				return errors.New("Parse module aux failed: " + err.Error())
+			}
+			continue
+		} else if t == FlagOpcodeSlotInfo {
+			// Slot id, slot size and expires slot size, only a hint for redis cluster.
+			for i := 0; i < 3; i++ {
+				if _, _, err := d.loadLen(); err != nil {
+					return errors.New("Parse slot info failed: " + err.Error())
+				}
+			}
+			continue
+		} else if t == FlagOpcodeEOF {
+			return d.verifyChecksum()
+		}
+		// Read key
+		key, err := d.loadString()
+		if err != nil {
+			return errors.New("Parse key failed: " + err.Error())
+		}
+		d.key = key
+		// Read value
+		keyObj := NewKeyObject(key, expire)
+		keyObj.Idle, keyObj.Freq = lruIdle, lfuFreq
+		// The expire, idle and freq only belong to this key, whatever its value.
+		expire, lruIdle, lfuFreq = NoExpire, -1, -1
+		if err := d.loadKey(KeyInfo{DB: d.db, Key: keyObj, Type: t}); err != nil {
+			return err
+		}
+	}
+}
+
+// loadKey decodes the value of the key, skips it when the filter rejects the
+// key or only keys are reported, or reports the serialized record to the RawHandler.
+func (d *Decoder) loadKey(key KeyInfo) error {
+	if d.Filter != nil && !d.Filter(key) {
+		if d.raw != nil {
+			d.input.stopCapture()
+		}
+		return d.skipObject(key.Type)
+	}
+	if d.KeysOnly {
+		start := d.input.Offset()
+		key.Key.encoding = typeEncodings[key.Type]
+		var err error
+		if key.Type == TypeString {
+			key.Key.encoding, err = d.skipStringEncoding()
+		} else {
+			err = d.skipObject(key.Type)
+		}
+		if err != nil {
+			return err
+		}
+		entry := KeyEntry{Field: key.Key, DB: key.DB, RDBType: key.Type, Size: uint64(d.input.Offset() - start)}
+		return d.handler.(KeyHandler).OnKey(entry)
+	}
+	if d.raw == nil {
+		return d.loadObject(key.Key, key.Type)
+	}
+	if err := d.skipObject(key.Type); err != nil {
+		return err
+	}
+	return d.raw.OnRawKey(key, d.input.stopCapture())
+}
+
+func (d *Decoder) loadObject(keyObj KeyObject, t byte) error {
+	keyObj.encoding = typeEncodings[t]
+	if t == TypeString {
+		if err := d.readString(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeList {
+		if err := d.readList(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeSet {
+		if err := d.readSet(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeZset || t == TypeZset2 {
+		if err := d.readZSet(keyObj, t); err != nil {
+			return err
+		}
+	} else if t == TypeHash {
+		if err := d.readHashMap(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeListQuickList { // quicklist + ziplist to realize linked list
+		if err := d.readListWithQuickList(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeHashZipMap {
+		if err := d.readHashMapWithZipmap(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeListZipList {
+		if err := d.readListWithZipList(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeSetIntSet {
+		if err := d.readIntSet(keyObj); err != nil {
+			return err
+		}
+		//return d.loadIntSet(key, expire)
+	} else if t == TypeZsetZipList {
+		if err := d.readZipListSortSet(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeHashZipList {
+		if err := d.readHashMapZiplist(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeStreamListPacks || t == TypeStreamListPacks2 || t == TypeStreamListPacks3 {
+		if err := d.loadStreamListPack(keyObj, t); err != nil {
+			return err
+		}
+	} else if t == TypeHashListPack {
+		if err := d.readHashMapListPack(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeZsetListPack {
+		if err := d.readListPackSortSet(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeListQuickList2 {
+		if err := d.readListWithQuickList2(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeSetListPack {
+		if err := d.readSetListPack(keyObj); err != nil {
+			return err
+		}
+	} else if t == TypeModule || t == TypeModule2 {
+		if err := d.readModule(keyObj, t); err != nil {
+			return err
+		}
+	} else {
+		return errors.New(fmt.Sprintf("unknown object type %d", t))
+	}
+
+	return nil
+}
+
+func (d *Decoder) loadLen() (length uint64, isEncode bool, err error) {
+	buf, err := d.input.ReadByte()
+	if err != nil {
+		return
+	}
+	typeLen := (buf & 0xc0) >> 6
+	if typeLen == TypeEncVal || typeLen == Type6Bit {
+		/* Read a 6 bit encoding type or 6 bit len. */
+		if typeLen == TypeEncVal {
+			isEncode = true
+		}
+		length = uint64(buf) & 0x3f
+	} else if typeLen == Type14Bit {
+		/* Read a 14 bit len, need read next byte. */
+		nb, err := d.input.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		length = (uint64(buf)&0x3f)<<8 | uint64(nb)
+	} else if buf == Type32Bit {
+		_, err = io.ReadFull(d.input, d.buff[0:4])
+		if err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint32(d.buff))
+	} else if buf == Type64Bit {
+		_, err = io.ReadFull(d.input, d.buff)
+		if err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(d.buff)
+	} else {
+		err = errors.New(fmt.Sprintf("unknown length encoding %d in loadLen()", typeLen))
+	}
+
+	return
+}
+
+func (d *Decoder) loadString() ([]byte, error) {
+	length, needEncode, err := d.loadLen()
+	if err != nil {
+		return nil, err
+	}
+
+	if needEncode {
+		switch length {
+		case EncodeInt8:
+			b, err := d.input.ReadByte()
+			return []byte(strconv.Itoa(int(int8(b)))), err
+		case EncodeInt16:
+			b, err := d.loadUint16()
+			return []byte(strconv.Itoa(int(int16(b)))), err
+		case EncodeInt32:
+			b, err := d.loadUint32()
+			return []byte(strconv.Itoa(int(int32(b)))), err
+		case EncodeLZF:
+			res, err := d.loadLZF()
+			return res, err
+		default:
+			return []byte{}, errors.New("Unknown string encode type ")
+		}
+	}
+
+	return d.readBytes(length)
+}
+
+// Read n bytes, big strings grow with the data actually read so that a
+// corrupt length fails on EOF instead of allocating all of it upfront.
+func (d *Decoder) readBytes(n uint64) ([]byte, error) {
+	if n <= maxPreAlloc {
+		res := make([]byte, n)
+		_, err := io.ReadFull(d.input, res)
+		return res, err
+	}
+	var res bytes.Buffer
+	if _, err := io.CopyN(&res, d.input, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return res.Bytes(), nil
+}
+
+func (d *Decoder) loadUint16() (res uint16, err error) {
+	_, err = io.ReadFull(d.input, d.buff[:2])
+	if err != nil {
+		return
+	}
+
+	res = binary.LittleEndian.Uint16(d.buff[:2])
+	return
+}
+
+func (d *Decoder) loadUint32() (res uint32, err error) {
+	_, err = io.ReadFull(d.input, d.buff[:4])
+	if err != nil {
+		return
+	}
+	res = binary.LittleEndian.Uint32(d.buff[:4])
+	return
+}
+
+func (d *Decoder) loadFloat() (float64, error) {
+	b, err := d.input.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b == 0xff {
+		return NegInf, nil
+	} else if b == 0xfe {
+		return PosInf, nil
+	} else if b == 0xfd {
+		return Nan, nil
+	}
+
+	floatBytes := make([]byte, b)
+	_, err = io.ReadFull(d.input, floatBytes)
+	if err != nil {
+		return 0, err
+	}
+	float, err := strconv.ParseFloat(string(floatBytes), 64)
+	return float, err
+}
+
+// 8 bytes unix time in milliseconds, little endian.
+func (d *Decoder) loadMillisecondTime() (uint64, error) {
+	if _, err := io.ReadFull(d.input, d.buff); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(d.buff), nil
+}
+
+// 8 bytes float64, follow IEEE754 float64 stddef (standard definitions)
+func (d *Decoder) loadBinaryFloat() (float64, error) {
+	if _, err := io.ReadFull(d.input, d.buff); err != nil {
+		return 0, err
+	}
+	bits := binary.LittleEndian.Uint64(d.buff)
+	return math.Float64frombits(bits), nil
+}
+
+func (d *Decoder) loadLZF() (res []byte, err error) {
+	ilength, _, err := d.loadLen()
+	if err != nil {
+		return
+	}
+	ulength, _, err := d.loadLen()
+	if err != nil {
+		return
+	}
+	val, err := d.readBytes(ilength)
+	if err != nil {
+		return
+	}
+	// A lzf back reference expands 3 bytes to 264 at most.
+	if ulength > ilength*88+1 {
+		return nil, errLZFCorrupt
+	}
+	return lzfDecompress(val, int(ilength), int(ulength))
+}
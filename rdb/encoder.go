@@ -0,0 +1,455 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Versions introducing the records written by the Encoder.
+	versionExpireMs   = 3   // EXPIRETIME_MS
+	versionAux        = 7   // AUX and RESIZEDB
+	versionModule2    = 8   // ZSET_2 and MODULE_2
+	versionStream     = 9   // STREAM_LISTPACKS, IDLE, FREQ and MODULE_AUX
+	versionStream2    = 10  // STREAM_LISTPACKS_2 and FUNCTION2
+	versionStream3    = 11  // STREAM_LISTPACKS_3
+	streamNodeEntries = 100 // Entries by listpack node of a stream, as stream-node-max-entries
+)
+
+// Encoder writes the records of the object model as a RDB stream of the
+// chosen version, the reverse of the Decoder. It implements Handler, so a
+// dump is rewritten by decoding it into an Encoder:
+//
+//	enc, _ := rdb.NewEncoder(output, 9)
+//	err := rdb.NewDecoder(input, enc).Decode()
+//
+// Values are written with the plain encodings (linked list, hash table...),
+// redis converts them to the compact ones when loading. OnEnd writes the EOF
+// opcode and the CRC64 footer, then flushes the output.
+type Encoder struct {
+	output  *bufio.Writer
+	version int
+	crc     uint64
+	started bool // Header written
+	buff    []byte
+}
+
+func NewEncoder(writer io.Writer, version int) (*Encoder, error) {
+	if version < VersionMin || version > VersionMax {
+		return nil, errors.New(fmt.Sprintf("RDB version %d is not supported, %d to %d", version, VersionMin, VersionMax))
+	}
+	return &Encoder{output: bufio.NewWriter(writer), version: version, buff: make([]byte, 8)}, nil
+}
+
+// Version returns the RDB version written by the Encoder.
+func (e *Encoder) Version() int {
+	return e.version
+}
+
+func (e *Encoder) OnAux(aux AuxField) error {
+	e.header()
+	if e.version < versionAux {
+		return nil
+	}
+	e.writeByte(FlagOpcodeAux)
+	e.writeString([]byte(ToString(aux.Field)))
+	e.writeString([]byte(ToString(aux.Val)))
+	return nil
+}
+
+func (e *Encoder) OnSelectDB(db SelectionDB) error {
+	e.header()
+	e.writeByte(FlagOpcodeSelectDB)
+	e.writeLen(db.Index)
+	return nil
+}
+
+func (e *Encoder) OnResizeDB(resize ResizeDB) error {
+	e.header()
+	if e.version < versionAux {
+		return nil
+	}
+	e.writeByte(FlagOpcodeResizeDB)
+	e.writeLen(resize.DBSize)
+	e.writeLen(resize.ExpireSize)
+	return nil
+}
+
+func (e *Encoder) OnString(s StringObject) error {
+	e.key(s.Field, TypeString)
+	e.writeString([]byte(ToString(s.Val)))
+	return nil
+}
+
+func (e *Encoder) OnList(l ListObject) error {
+	e.key(l.Field, TypeList)
+	e.writeLen(uint64(len(l.Entries)))
+	for _, entry := range l.Entries {
+		e.writeString([]byte(entry))
+	}
+	return nil
+}
+
+func (e *Encoder) OnSet(s Set) error {
+	e.key(s.Field, TypeSet)
+	e.writeLen(uint64(len(s.Entries)))
+	for _, entry := range s.Entries {
+		e.writeString([]byte(entry))
+	}
+	return nil
+}
+
+// Scores are saved as binary doubles since RDB 8, as strings before.
+func (e *Encoder) OnZSet(zs SortedSet) error {
+	t := byte(TypeZset2)
+	if e.version < versionModule2 {
+		t = TypeZset
+	}
+	e.key(zs.Field, t)
+	e.writeLen(uint64(len(zs.Entries)))
+	for _, entry := range zs.Entries {
+		e.writeString([]byte(ToString(entry.Field)))
+		if t == TypeZset2 {
+			binary.LittleEndian.PutUint64(e.buff, math.Float64bits(entry.Score))
+			e.write(e.buff)
+		} else {
+			e.writeFloat(entry.Score)
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) OnHash(hm HashMap) error {
+	e.key(hm.Field, TypeHash)
+	e.writeLen(uint64(len(hm.Entry)))
+	for _, entry := range hm.Entry {
+		e.writeString([]byte(entry.Field))
+		e.writeString([]byte(entry.Value))
+	}
+	return nil
+}
+
+// Streams are saved with the listpacks layout of the target version, the
+// metadata of redis 7.0 and 7.2 is dropped by older versions and derived
+// for the newer ones.
+func (e *Encoder) OnStream(rs RedisStream) error {
+	if e.version < versionStream {
+		return errors.New(fmt.Sprintf("stream %s needs RDB version %d at least", rs.Key(), versionStream))
+	}
+	t := byte(TypeStreamListPacks)
+	if e.version >= versionStream3 {
+		t = TypeStreamListPacks3
+	} else if e.version >= versionStream2 {
+		t = TypeStreamListPacks2
+	}
+	if t >= TypeStreamListPacks2 {
+		rs = rs.upgrade()
+	}
+	e.key(rs.Field, t)
+
+	messages := rs.Messages()
+	e.writeLen(uint64((len(messages) + streamNodeEntries - 1) / streamNodeEntries))
+	for i := 0; i < len(messages); i += streamNodeEntries {
+		end := i + streamNodeEntries
+		if end > len(messages) {
+			end = len(messages)
+		}
+		e.writeString(streamId128(messages[i].Id))
+		e.writeString(streamNode(messages[i:end]))
+	}
+
+	e.writeLen(rs.Length)
+	e.writeStreamId(rs.LastId)
+	if t >= TypeStreamListPacks2 {
+		e.writeStreamId(rs.FirstId)
+		e.writeStreamId(rs.MaxDeletedId)
+		e.writeLen(rs.EntriesAdded)
+	}
+
+	e.writeLen(uint64(len(rs.Groups)))
+	for _, group := range rs.Groups {
+		lastId, err := parseStreamId(group.LastId)
+		if err != nil {
+			return errors.New("stream " + rs.Key() + " group " + group.Name + ": " + err.Error())
+		}
+		e.writeString([]byte(group.Name))
+		e.writeStreamId(lastId)
+		if t >= TypeStreamListPacks2 {
+			e.writeLen(group.EntriesRead)
+		}
+		pending := group.Pending()
+		e.writeLen(uint64(len(pending)))
+		for _, nack := range pending {
+			e.write(streamId128(nack.Id))
+			binary.LittleEndian.PutUint64(e.buff, nack.DeliveryTime)
+			e.write(e.buff)
+			e.writeLen(nack.DeliveryCount)
+		}
+		e.writeLen(uint64(len(group.Consumers)))
+		for _, consumer := range group.Consumers {
+			e.writeString([]byte(consumer.Name))
+			binary.LittleEndian.PutUint64(e.buff, consumer.SeenTime)
+			e.write(e.buff)
+			if t >= TypeStreamListPacks3 {
+				binary.LittleEndian.PutUint64(e.buff, consumer.ActiveTime)
+				e.write(e.buff)
+			}
+			ids := make([]StreamId, 0, len(consumer.PendingEntryList))
+			for id := range consumer.PendingEntryList {
+				streamId, err := parseStreamId(id)
+				if err != nil {
+					return errors.New("stream " + rs.Key() + " consumer " + consumer.Name + ": " + err.Error())
+				}
+				ids = append(ids, streamId)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+			e.writeLen(uint64(len(ids)))
+			for _, id := range ids {
+				e.write(streamId128(id))
+			}
+		}
+	}
+	return nil
+}
+
+// Libraries are saved as their code since redis 7.0 GA, the code of the
+// release candidates gets the shebang naming the engine and the library.
+func (e *Encoder) OnFunction(f FunctionLibrary) error {
+	e.header()
+	if e.version < versionStream2 {
+		return errors.New(fmt.Sprintf("function %s needs RDB version %d at least", f.Name, versionStream2))
+	}
+	code := f.Code
+	if !strings.HasPrefix(code, "#!") {
+		code = "#!" + strings.ToLower(f.Engine) + " name=" + f.Name + "\n" + code
+	}
+	e.writeByte(FlagOpcodeFunction2)
+	e.writeString([]byte(code))
+	return nil
+}
+
+// Module values are written back as they were serialized, only values with
+// opcodes (RDB_TYPE_MODULE_2) keep it.
+func (e *Encoder) OnModule(m ModuleObject) error {
+	if e.version < versionModule2 {
+		return errors.New(fmt.Sprintf("module %s needs RDB version %d at least", m.Module, versionModule2))
+	}
+	if len(m.Raw) == 0 {
+		return errors.New("module " + m.Module + " of key " + m.Key() + " has no serialized value")
+	}
+	id, err := ModuleTypeID(m.Module)
+	if err != nil {
+		return err
+	}
+	e.key(m.Field, TypeModule2)
+	e.writeLen(id | m.EncVer&moduleEncVerMask)
+	e.write(m.Raw)
+	return nil
+}
+
+func (e *Encoder) OnModuleAux(aux ModuleAux) error {
+	e.header()
+	if e.version < versionStream {
+		return errors.New(fmt.Sprintf("module aux %s needs RDB version %d at least", aux.Module, versionStream))
+	}
+	id, err := ModuleTypeID(aux.Module)
+	if err != nil {
+		return err
+	}
+	e.writeByte(FlagOpcodeModuleAux)
+	e.writeLen(id | aux.EncVer&moduleEncVerMask)
+	e.writeLen(ModuleOpcodeUInt)
+	e.writeLen(aux.When)
+	e.write(aux.Raw)
+	return nil
+}
+
+// WriteRecord writes a record as it is serialized, a key reported by
+// RawHandler.OnRawKey for example. The record must be of the same version.
+func (e *Encoder) WriteRecord(record []byte) error {
+	e.header()
+	e.write(record)
+	return nil
+}
+
+// OnEnd terminates the stream with the EOF opcode and its checksum.
+func (e *Encoder) OnEnd() error {
+	e.header()
+	e.writeByte(FlagOpcodeEOF)
+	if e.version >= VersionChecksum {
+		// The checksum is not part of itself, write it around the crc.
+		binary.LittleEndian.PutUint64(e.buff, e.crc)
+		if _, err := e.output.Write(e.buff); err != nil {
+			return err
+		}
+	}
+	return e.output.Flush()
+}
+
+// "REDIS" and the version on 4 digits, written before the first record.
+func (e *Encoder) header() {
+	if !e.started {
+		e.started = true
+		e.write([]byte(fmt.Sprintf("%s%04d", REDIS, e.version)))
+	}
+}
+
+// Expire, idle and freq of the key followed by its type and name.
+func (e *Encoder) key(key KeyObject, t byte) {
+	e.header()
+	if key.HasExpire() {
+		ms := key.ExpireMs()
+		if e.version >= versionExpireMs {
+			e.writeByte(FlagOpcodeExpireTimeMs)
+			binary.LittleEndian.PutUint64(e.buff, uint64(ms))
+			e.write(e.buff)
+		} else {
+			// Seconds of a 32 bits signed integer, the milliseconds are lost.
+			e.writeByte(FlagOpcodeExpireTime)
+			binary.LittleEndian.PutUint32(e.buff, uint32(int32(ms/1000)))
+			e.write(e.buff[:4])
+		}
+	}
+	if e.version >= versionStream {
+		if key.HasIdle() {
+			e.writeByte(FlagOpcodeIdle)
+			e.writeLen(uint64(key.Idle))
+		}
+		if key.HasFreq() {
+			e.writeByte(FlagOpcodeFreq)
+			e.writeByte(byte(key.Freq))
+		}
+	}
+	e.writeByte(t)
+	e.writeString([]byte(key.Value()))
+}
+
+// Errors of the output are kept by the bufio.Writer and returned by the flush of OnEnd.
+func (e *Encoder) write(p []byte) {
+	e.crc = crc64Update(e.crc, p)
+	e.output.Write(p)
+}
+
+func (e *Encoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+func (e *Encoder) writeLen(length uint64) {
+	if length < 1<<6 {
+		e.writeByte(byte(length) | Type6Bit<<6)
+	} else if length < 1<<14 {
+		e.write([]byte{byte(length>>8) | Type14Bit<<6, byte(length)})
+	} else if length <= math.MaxUint32 {
+		e.writeByte(Type32Bit)
+		binary.BigEndian.PutUint32(e.buff, uint32(length))
+		e.write(e.buff[:4])
+	} else {
+		e.writeByte(Type64Bit)
+		binary.BigEndian.PutUint64(e.buff, length)
+		e.write(e.buff)
+	}
+}
+
+// Strings holding an integer of 32 bits at most are saved as integers, like rdbTryIntegerEncoding.
+func (e *Encoder) writeString(s []byte) {
+	if v, ok := canonicalInt(s); ok && v >= math.MinInt32 && v <= math.MaxInt32 {
+		if v >= math.MinInt8 && v <= math.MaxInt8 {
+			e.write([]byte{TypeEncVal<<6 | EncodeInt8, byte(v)})
+		} else if v >= math.MinInt16 && v <= math.MaxInt16 {
+			e.write([]byte{TypeEncVal<<6 | EncodeInt16, byte(v), byte(v >> 8)})
+		} else {
+			e.write([]byte{TypeEncVal<<6 | EncodeInt32, byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+		}
+		return
+	}
+	e.writeLen(uint64(len(s)))
+	e.write(s)
+}
+
+// Old format of the scores, a length byte and the "%.17g" string, with special lengths for nan and infinities.
+func (e *Encoder) writeFloat(f float64) {
+	if math.IsNaN(f) {
+		e.writeByte(0xfd)
+	} else if math.IsInf(f, 1) {
+		e.writeByte(0xfe)
+	} else if math.IsInf(f, -1) {
+		e.writeByte(0xff)
+	} else {
+		s := strconv.FormatFloat(f, 'g', 17, 64)
+		e.writeByte(byte(len(s)))
+		e.write([]byte(s))
+	}
+}
+
+func (e *Encoder) writeStreamId(id StreamId) {
+	e.writeLen(id.Ms)
+	e.writeLen(id.Sequence)
+}
+
+// Stream id as 128 bits big endian, the key of the listpack nodes and the pending entries.
+func streamId128(id StreamId) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Sequence)
+	return b
+}
+
+// Listpack of a stream node, the master entry holds the fields of the first
+// message and the ids are deltas to it:
+// | count | deleted | num-fields | field_1 | ... | field_N | 0 |
+// | flags | ms-diff | seq-diff | [num-fields | field_1 |] value_1 | ... | lp-count |
+func streamNode(messages []StreamMessage) []byte {
+	master := messages[0]
+	var count, deleted int64
+	for _, message := range messages {
+		if message.Deleted {
+			deleted++
+		} else {
+			count++
+		}
+	}
+	entries := [][]byte{formatInt(count), formatInt(deleted), formatInt(int64(len(master.Fields)))}
+	for _, field := range master.Fields {
+		entries = append(entries, []byte(field.Field))
+	}
+	entries = append(entries, formatInt(0))
+
+	for _, message := range messages {
+		flags := int64(StreamItemFlagNone)
+		if message.Deleted {
+			flags |= StreamItemFlagDeleted
+		}
+		sameFields := len(message.Fields) == len(master.Fields)
+		for i := 0; i < len(message.Fields) && sameFields; i++ {
+			sameFields = message.Fields[i].Field == master.Fields[i].Field
+		}
+		if sameFields {
+			flags |= StreamItemFlagSameFields
+		}
+		entries = append(entries, formatInt(flags), formatInt(int64(message.Id.Ms-master.Id.Ms)), formatInt(int64(message.Id.Sequence-master.Id.Sequence)))
+		lpCount := int64(len(message.Fields)) + 3
+		if !sameFields {
+			entries = append(entries, formatInt(int64(len(message.Fields))))
+			lpCount += int64(len(message.Fields)) + 1
+		}
+		for _, field := range message.Fields {
+			if !sameFields {
+				entries = append(entries, []byte(field.Field))
+			}
+			entries = append(entries, []byte(field.Value))
+		}
+		entries = append(entries, formatInt(lpCount))
+	}
+	return buildListPack(entries)
+}
+
+func formatInt(v int64) []byte {
+	return strconv.AppendInt(nil, v, 10)
+}
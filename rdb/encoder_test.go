@@ -0,0 +1,194 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// recordedKey is a key of a dump as compared by the tests.
+type recordedKey struct {
+	DB       uint64
+	Type     string
+	Key      string
+	ExpireMs int64
+	Value    string
+}
+
+// recorder keeps the keys of a dump in order.
+type recorder struct {
+	NopHandler
+	db   uint64
+	keys []recordedKey
+}
+
+func (r *recorder) OnSelectDB(db SelectionDB) error {
+	r.db = db.Index
+	return nil
+}
+
+func (r *recorder) add(k KeyRecord) error {
+	r.keys = append(r.keys, recordedKey{DB: r.db, Type: k.Type(), Key: k.Key(), ExpireMs: k.Meta().ExpireMs(), Value: k.Value()})
+	return nil
+}
+
+func (r *recorder) OnString(s StringObject) error { return r.add(s) }
+func (r *recorder) OnList(l ListObject) error     { return r.add(l) }
+func (r *recorder) OnSet(s Set) error             { return r.add(s) }
+func (r *recorder) OnZSet(zs SortedSet) error     { return r.add(zs) }
+func (r *recorder) OnHash(hm HashMap) error       { return r.add(hm) }
+func (r *recorder) OnModule(m ModuleObject) error { return r.add(m) }
+
+func (r *recorder) OnStream(rs RedisStream) error {
+	if err := r.add(rs); err != nil {
+		return err
+	}
+	// The listpack nodes are rebuilt by the Encoder, only the live messages are compared.
+	messages := make([]StreamMessage, 0, len(rs.Entries))
+	for _, message := range rs.Messages() {
+		if !message.Deleted {
+			messages = append(messages, message)
+		}
+	}
+	value, err := json.Marshal(map[string]interface{}{"LastId": rs.LastId, "Length": rs.Length, "Messages": messages, "Groups": rs.Groups})
+	r.keys[len(r.keys)-1].Value = string(value)
+	return err
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	dump, err := ioutil.ReadFile(filepath.Join("..", "teststub", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dump
+}
+
+// decodeKeys returns the keys of the dump and its RDB version.
+func decodeKeys(t *testing.T, dump []byte) ([]recordedKey, int) {
+	t.Helper()
+	r := &recorder{}
+	decoder := NewDecoder(bytes.NewReader(dump), r)
+	if err := decoder.Decode(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return r.keys, decoder.Version()
+}
+
+// encodeKeys rewrites the dump in the version with the Encoder.
+func encodeKeys(t *testing.T, dump []byte, version int) []byte {
+	t.Helper()
+	var output bytes.Buffer
+	enc, err := NewEncoder(&output, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDecoder(bytes.NewReader(dump), enc).Decode(); err != nil {
+		t.Fatalf("encode into version %d: %v", version, err)
+	}
+	return output.Bytes()
+}
+
+func compareKeys(t *testing.T, name string, got, want []recordedKey) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d keys, want %d", name, len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: key %d is %+v, want %+v", name, i, got[i], want[i])
+		}
+	}
+}
+
+// Every fixture decodes to the same keys once rewritten in its own version.
+func TestEncoderRoundTrip(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("..", "teststub", "*.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		name := filepath.Base(fixture)
+		dump := readFixture(t, name)
+		want, version := decodeKeys(t, dump)
+		got, _ := decodeKeys(t, encodeKeys(t, dump, version))
+		compareKeys(t, name, got, want)
+	}
+}
+
+// Before RDB 3 the expires are saved in seconds, as 32 bits integers.
+func TestEncoderExpireSeconds(t *testing.T) {
+	for _, name := range []string{"keys_with_expiry.rdb", "keys_with_mixed_expiry.rdb"} {
+		dump := readFixture(t, name)
+		want, _ := decodeKeys(t, dump)
+		expiring := 0
+		for i := range want {
+			if want[i].ExpireMs >= 0 {
+				want[i].ExpireMs = want[i].ExpireMs / 1000 * 1000
+				expiring++
+			}
+		}
+		if expiring == 0 {
+			t.Fatalf("%s: no key with an expire", name)
+		}
+		got, version := decodeKeys(t, encodeKeys(t, dump, 2))
+		if version != 2 {
+			t.Fatalf("%s: version %d, want 2", name, version)
+		}
+		compareKeys(t, name, got, want)
+	}
+}
+
+// A stream of RDB 9 rewritten for redis 7 gets the metadata redis derives when it loads it.
+func TestEncoderStreamUpgrade(t *testing.T) {
+	type upgrade struct {
+		added       uint64
+		first       StreamId
+		entriesRead map[string]uint64 // By group
+	}
+	tests := map[string]map[string]upgrade{
+		"dump-stream.rdb": {
+			"listpack": {150, StreamId{1528507816450, 0}, map[string]uint64{
+				"g1": streamInvalidEntriesRead, "g2": streamInvalidEntriesRead, "g3": streamInvalidEntriesRead, "g4": 150,
+			}},
+			// 20 entries trimmed and 2 deleted, redis 5.0 saved a length of 130.
+			"trim": {130, StreamId{1528512139400, 0}, nil},
+		},
+		"dumpV9.rdb": {
+			"stream": {3, StreamId{1569553992318, 0}, map[string]uint64{"group": 0}},
+		},
+	}
+	for name, streams := range tests {
+		dump := encodeKeys(t, readFixture(t, name), versionStream3)
+		upgraded := decodeStreams(t, dump)
+		for key, want := range streams {
+			rs := upgraded[key]
+			if rs.EntriesAdded != want.added || rs.FirstId != want.first || rs.MaxDeletedId != (StreamId{}) {
+				t.Errorf("%s: %s has %d entries added, first id %s, max deleted id %s, want %d, %s, 0-0",
+					name, key, rs.EntriesAdded, rs.FirstId, rs.MaxDeletedId, want.added, want.first)
+			}
+			if len(rs.Groups) != len(want.entriesRead) {
+				t.Fatalf("%s: %s has %d groups, want %d", name, key, len(rs.Groups), len(want.entriesRead))
+			}
+			for _, group := range rs.Groups {
+				if group.EntriesRead != want.entriesRead[group.Name] {
+					t.Errorf("%s: %s group %s read %d entries, want %d", name, key, group.Name, group.EntriesRead, want.entriesRead[group.Name])
+				}
+				for _, consumer := range group.Consumers {
+					if consumer.ActiveTime != consumer.SeenTime {
+						t.Errorf("%s: %s consumer %s active at %d, seen at %d", name, key, consumer.Name, consumer.ActiveTime, consumer.SeenTime)
+					}
+				}
+			}
+		}
+		// The metadata of redis 7 is kept as is once saved.
+		again := decodeStreams(t, encodeKeys(t, dump, versionStream3))
+		for key, rs := range upgraded {
+			if got := again[key]; got.EntriesAdded != rs.EntriesAdded || got.FirstId != rs.FirstId || got.Value() != rs.Value() {
+				t.Errorf("%s: %s is not kept by a second rewrite: %s, want %s", name, key, got.Value(), rs.Value())
+			}
+		}
+	}
+}
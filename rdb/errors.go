@@ -0,0 +1,36 @@
+package rdb
+
+import (
+	"fmt"
+)
+
+// ParseError describes the record of a RDB stream that failed to decode,
+// the cause is available through errors.Is and errors.As.
+type ParseError struct {
+	Offset int64  // Offset in the stream where the failing record started
+	DB     uint64 // Database index of the failing record
+	Key    string // Key of the failing record, empty when the key is not read yet
+	Type   byte   // RDB type byte of the failing record, object type or opcode
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("rdb: offset %d, db %d, key '%s', type %d: %s", e.Offset, e.DB, e.Key, e.Type, e.Err.Error())
+	}
+	return fmt.Sprintf("rdb: offset %d, db %d, type %d: %s", e.Offset, e.DB, e.Type, e.Err.Error())
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumError reports a CRC64 at the end of the stream which differs from its content.
+type ChecksumError struct {
+	Expected uint64 // Checksum stored in the stream
+	Actual   uint64 // Checksum computed over the stream
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch, expected %016x, computed %016x", e.Expected, e.Actual)
+}
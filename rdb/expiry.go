@@ -0,0 +1,125 @@
+package rdb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	persistentKeys  = 10 // Biggest keys without expire by database
+	ttlBucketNum    = 6
+	expiredTTLIndex = 0
+)
+
+var (
+	// Upper bounds (exclusive) of the time to expiry, after the expired keys, the last bucket is unbounded.
+	ttlBounds = []time.Duration{time.Minute, time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+	ttlLabels = []string{"expired", "< 1m", "< 1h", "< 1d", "< 7d", ">= 7d"}
+)
+
+// DBExpiry gathers the keys of a database with and without expire.
+type DBExpiry struct {
+	Keys           uint64
+	Expiring       uint64 // Keys with an expire
+	PersistentSize uint64 // Bytes of the keys without expire
+	ExpiringSize   uint64 // Bytes of the keys with an expire
+	TTLCount       [ttlBucketNum]uint64
+	TTLBytes       [ttlBucketNum]uint64
+	TTLMemory      [ttlBucketNum]uint64
+	Persistent     *TopKeys // Biggest keys without expire
+}
+
+// ExpiryReport gathers the expiry of the keys by database, the time to
+// expiry is relative to the ctime aux field of the dump, when it was saved,
+// or to now when the dump has none.
+type ExpiryReport struct {
+	DBs   map[uint64]*DBExpiry
+	ctime time.Time
+}
+
+// OnAux reads the time the dump was saved, in seconds.
+func (er *ExpiryReport) OnAux(aux AuxField) {
+	if aux.Key() != "ctime" {
+		return
+	}
+	if ctime, err := strconv.ParseInt(aux.Value(), 10, 64); err == nil && ctime > 0 {
+		er.ctime = time.Unix(ctime, 0).UTC()
+	}
+}
+
+// Reference of the time to expiry, the ctime of the dump or now.
+func (er *ExpiryReport) reference() time.Time {
+	if er.ctime.IsZero() {
+		return time.Now().UTC()
+	}
+	return er.ctime
+}
+
+func (er *ExpiryReport) Add(key TopKey, meta KeyObject) {
+	if er.DBs == nil {
+		er.DBs = make(map[uint64]*DBExpiry)
+	}
+	db, ok := er.DBs[key.DB]
+	if !ok {
+		db = &DBExpiry{Persistent: NewTopKeys(persistentKeys)}
+		er.DBs[key.DB] = db
+	}
+	db.Keys++
+	if !meta.HasExpire() {
+		db.PersistentSize += key.Bytes
+		db.Persistent.Add(key)
+		return
+	}
+	db.Expiring++
+	db.ExpiringSize += key.Bytes
+	i := expiredTTLIndex
+	if !meta.ExpiredAt(er.reference()) {
+		ttl := meta.Expire.Sub(er.reference())
+		i = sort.Search(len(ttlBounds), func(i int) bool { return ttl < ttlBounds[i] }) + 1
+	}
+	db.TTLCount[i]++
+	db.TTLBytes[i] += key.Bytes
+	db.TTLMemory[i] += key.Memory
+}
+
+func (er *ExpiryReport) Empty() bool {
+	return len(er.DBs) == 0
+}
+
+// Print writes the expiry of the keys of every database, the biggest keys
+// without expire are ranked by memory, by bytes when it is unknown.
+func (er *ExpiryReport) Print(ranking string) {
+	reference := "the ctime of the dump " + er.ctime.Format(time.RFC3339)
+	if er.ctime.IsZero() {
+		reference = "now, the dump has no ctime"
+	}
+	dbs := make([]uint64, 0, len(er.DBs))
+	for db := range er.DBs {
+		dbs = append(dbs, db)
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i] < dbs[j] })
+	for _, index := range dbs {
+		db := er.DBs[index]
+		println(fmt.Sprintf("\n-------- expiry of db %d -------\n", index))
+		println(fmt.Sprintf("%d keys without expire, %d bytes", db.Keys-db.Expiring, db.PersistentSize))
+		println(fmt.Sprintf("%d keys with an expire, %d bytes", db.Expiring, db.ExpiringSize))
+		if db.Expiring > 0 {
+			println(fmt.Sprintf("\nTime to expiry, relative to %s:", reference))
+			for i, label := range ttlLabels {
+				println(fmt.Sprintf("%-8s %d keys, %d bytes, %d bytes of memory", label, db.TTLCount[i], db.TTLBytes[i], db.TTLMemory[i]))
+			}
+		}
+		if keys := db.Persistent.Keys(ranking); len(keys) > 0 {
+			println("\nBiggest keys without expire:")
+			for i, key := range keys {
+				measure := fmt.Sprintf("%d bytes of memory", key.Memory)
+				if ranking == TopByBytes {
+					measure = fmt.Sprintf("%d bytes", key.Bytes)
+				}
+				println(fmt.Sprintf("%2d. %6s '%s' %s", i+1, key.Type, key.Key, measure))
+			}
+		}
+	}
+}
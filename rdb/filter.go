@@ -0,0 +1,165 @@
+package rdb
+
+import (
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"regexp"
+	"time"
+)
+
+// KeyFilter selects the keys by name, database, type and expiry before their
+// values are decoded, then by the size of their values once decoded.
+type KeyFilter struct {
+	Pattern       string         // Glob pattern of the key like the KEYS command, empty for every key
+	Regex         *regexp.Regexp // Regular expression of the key, nil for every key
+	DB            int            // Database of the key, -1 for every database
+	Type          string         // Type of the value as TYPE names it, empty for every type
+	Expiring      bool           // Only the keys with an expire
+	Persistent    bool           // Only the keys without expire
+	ExpiredBefore time.Time      // Only the keys expiring before, zero for every key
+
+	MinSize uint64 // ConcreteSize of the value at least
+	MaxSize uint64 // ConcreteSize of the value at most, 0 for no limit
+	MinLen  uint64 // Elements of the value at least, as ValueLen counts them
+}
+
+// NewKeyFilter returns the filter of the command line options, nil when every key is kept.
+func NewKeyFilter(opts protocol.Options) (*KeyFilter, error) {
+	f := &KeyFilter{
+		Pattern:       opts.KeyPattern,
+		DB:            opts.DB,
+		Type:          opts.KeyType,
+		Expiring:      opts.Expiring,
+		Persistent:    opts.Persistent,
+		ExpiredBefore: opts.ExpiredBefore,
+		MinSize:       opts.MinSize,
+		MaxSize:       opts.MaxSize,
+		MinLen:        opts.MinLen,
+	}
+	if opts.KeyRegex != "" {
+		regex, err := regexp.Compile(opts.KeyRegex)
+		if err != nil {
+			return nil, err
+		}
+		f.Regex = regex
+	}
+	if f.Pattern == "" && f.Regex == nil && f.DB < 0 && f.Type == "" && !f.Expiring && !f.Persistent &&
+		f.ExpiredBefore.IsZero() && f.MinSize == 0 && f.MaxSize == 0 && f.MinLen == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Match whether the key is kept, its value is skipped otherwise.
+func (f *KeyFilter) Match(key KeyInfo) bool {
+	if f.DB >= 0 && key.DB != uint64(f.DB) {
+		return false
+	}
+	if f.Type != "" && key.TypeName() != f.Type {
+		return false
+	}
+	expiring := key.Key.HasExpire()
+	if (f.Expiring && !expiring) || (f.Persistent && expiring) {
+		return false
+	}
+	if !f.ExpiredBefore.IsZero() && (!expiring || !key.Key.Expire.Before(f.ExpiredBefore)) {
+		return false
+	}
+	if f.Pattern != "" && !globMatch(f.Pattern, key.Key.Value()) {
+		return false
+	}
+	return f.Regex == nil || f.Regex.MatchString(key.Key.Value())
+}
+
+// MatchValue whether the decoded value of a matching key is kept.
+func (f *KeyFilter) MatchValue(entity KeyRecord) bool {
+	size := entity.ConcreteSize()
+	if size < f.MinSize || (f.MaxSize > 0 && size > f.MaxSize) {
+		return false
+	}
+	return entity.ValueLen() >= f.MinLen
+}
+
+// globMatch matches the string against the pattern like stringmatchlen of
+// redis: * and ? wildcards, [abc], [^abc] and [a-z] classes, \ escapes.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for {
+				if len(pattern) == 0 {
+					// Unterminated class, the last character closes it.
+					break
+				}
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[0] >= start && s[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == s[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+			if len(pattern) == 0 {
+				return len(s) == 0
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,80 @@
+package rdb
+
+import (
+	"testing"
+)
+
+// The glob patterns of the KEYS command, as stringmatchlen of redis.
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "user:42", true},
+		{"**", "user:42", true},
+		{"user:*", "user:", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users:42", false},
+		{"*:data", "session:1:data", true},
+		{"*:data", "session:1:data2", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"a*", "a", true},
+		{"a*b", "a", false},
+
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h?llo", "heello", false},
+		{"?", "", false},
+
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hbllo", true}, // Reversed range
+		{"[0-9][0-9]", "42", true},
+		{"[0-9][0-9]", "4a", false},
+		{"[a-]", "_", true}, // Range of ] to a, as redis
+		{"[a-]", "-", false},
+		{"[]", "a", false},
+
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[^a-c]llo", "hdllo", true},
+		{"h[^a-c]llo", "hbllo", false},
+		{"[^a]", "", false},
+
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h\?llo`, "h?llo", true},
+		{`h\?llo`, "hello", false},
+		{`\[a]`, "[a]", true},
+		{`\[a]`, "a", false},
+		{`[\]]`, "]", true},
+		{`[\^a]`, "^", true},
+		{`[a\-z]`, "-", true},
+		{`[a\-z]`, "b", false},
+		{`a\`, `a\`, true}, // A trailing backslash is a character
+		{`a\`, "a", false},
+
+		// An unterminated class ends the pattern.
+		{"[abc", "b", true},
+		{"[abc", "d", false},
+		{"[abc", "ab", false},
+		{"[^ab", "c", true},
+		{"[^ab", "a", false},
+		{"x[", "x", false},
+		{"x[", "xa", false},
+		{"[a-", "-", true},
+	}
+	for _, test := range tests {
+		if match := globMatch(test.pattern, test.key); match != test.match {
+			t.Errorf("globMatch(%q, %q) = %t, want %t", test.pattern, test.key, match, test.match)
+		}
+	}
+}
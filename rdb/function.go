@@ -0,0 +1,121 @@
+package rdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Library of Redis Functions, since redis 7.0.
+type FunctionLibrary struct {
+	Engine      string
+	Name        string
+	Description string // Only saved by the release candidates of redis 7.0
+	Code        string
+}
+
+// Since redis 7.0 GA the library is only its code, engine and name are read from the shebang:
+// #!<engine> name=<library> [key=value ...]
+func (d *Decoder) readFunction() error {
+	code, err := d.loadString()
+	if err != nil {
+		return err
+	}
+	library := FunctionLibrary{Code: ToString(code)}
+	if err := library.parseShebang(); err != nil {
+		return err
+	}
+	return d.handler.OnFunction(library)
+}
+
+// Redis 7.0 release candidates save name, engine, description and code.
+func (d *Decoder) readFunctionPreGA() error {
+	name, err := d.loadString()
+	if err != nil {
+		return err
+	}
+	engine, err := d.loadString()
+	if err != nil {
+		return err
+	}
+	library := FunctionLibrary{Name: ToString(name), Engine: ToString(engine)}
+	hasDesc, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	if hasDesc != 0 {
+		desc, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		library.Description = ToString(desc)
+	}
+	code, err := d.loadString()
+	if err != nil {
+		return err
+	}
+	library.Code = ToString(code)
+	return d.handler.OnFunction(library)
+}
+
+func (f *FunctionLibrary) parseShebang() error {
+	line := f.Code
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return errors.New("function library code without shebang")
+	}
+	parts := strings.Fields(line[2:])
+	if len(parts) == 0 {
+		return errors.New("function library shebang without engine")
+	}
+	f.Engine = parts[0]
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "name=") {
+			f.Name = part[len("name="):]
+		}
+	}
+	if f.Name == "" {
+		return errors.New("function library shebang without name")
+	}
+	return nil
+}
+
+// Write the library code to <dir>/<library>.<engine>, for example mylib.lua.
+func dumpFunction(dir string, f FunctionLibrary) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	fileName := filepath.Join(dir, filepath.Base(f.Name)+"."+strings.ToLower(f.Engine))
+	return ioutil.WriteFile(fileName, []byte(f.Code), 0644)
+}
+
+func (f FunctionLibrary) Type() string {
+	return protocol.Function
+}
+
+func (f FunctionLibrary) String() string {
+	return fmt.Sprintf("{Function: {Engine: %s, Library: %s, Code: %s}}", f.Engine, f.Name, f.Code)
+}
+
+func (f FunctionLibrary) Key() string {
+	return f.Name
+}
+
+func (f FunctionLibrary) Value() string {
+	return f.Code
+}
+
+func (f FunctionLibrary) ValueLen() uint64 {
+	return 0
+}
+
+// Size of the library code.
+func (f FunctionLibrary) ConcreteSize() uint64 {
+	return uint64(len(f.Code))
+}
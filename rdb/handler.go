@@ -0,0 +1,89 @@
+package rdb
+
+// Handler receives every record of a RDB stream in the order of the dump.
+// Returning an error from any callback stops the decoding and Decode
+// returns that error.
+type Handler interface {
+	OnAux(aux AuxField) error
+	OnSelectDB(db SelectionDB) error
+	OnResizeDB(resize ResizeDB) error
+	OnString(s StringObject) error
+	OnList(l ListObject) error
+	OnSet(s Set) error
+	OnZSet(zs SortedSet) error
+	OnHash(hm HashMap) error
+	OnStream(rs RedisStream) error
+	OnFunction(f FunctionLibrary) error
+	OnModule(m ModuleObject) error
+	OnModuleAux(aux ModuleAux) error
+	OnEnd() error
+}
+
+// RawHandler receives the keys as they are serialized in the dump, with
+// their expire and LRU/LFU opcodes, instead of their decoded values. The
+// other records are reported to the Handler callbacks as usual.
+type RawHandler interface {
+	Handler
+	OnRawKey(key KeyInfo, record []byte) error
+}
+
+// KeyHandler receives the keys without their values when Decoder.KeysOnly is set.
+type KeyHandler interface {
+	Handler
+	OnKey(key KeyEntry) error
+}
+
+// NopHandler ignores every record, embed it to implement only the callbacks you need.
+type NopHandler struct{}
+
+func (NopHandler) OnAux(aux AuxField) error {
+	return nil
+}
+
+func (NopHandler) OnSelectDB(db SelectionDB) error {
+	return nil
+}
+
+func (NopHandler) OnResizeDB(resize ResizeDB) error {
+	return nil
+}
+
+func (NopHandler) OnString(s StringObject) error {
+	return nil
+}
+
+func (NopHandler) OnList(l ListObject) error {
+	return nil
+}
+
+func (NopHandler) OnSet(s Set) error {
+	return nil
+}
+
+func (NopHandler) OnZSet(zs SortedSet) error {
+	return nil
+}
+
+func (NopHandler) OnHash(hm HashMap) error {
+	return nil
+}
+
+func (NopHandler) OnStream(rs RedisStream) error {
+	return nil
+}
+
+func (NopHandler) OnFunction(f FunctionLibrary) error {
+	return nil
+}
+
+func (NopHandler) OnModule(m ModuleObject) error {
+	return nil
+}
+
+func (NopHandler) OnModuleAux(aux ModuleAux) error {
+	return nil
+}
+
+func (NopHandler) OnEnd() error {
+	return nil
+}
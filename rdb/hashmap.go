@@ -2,9 +2,9 @@ package rdb
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/8090Lambert/go-redis-parser/protocol"
-	"strings"
 )
 
 // Some of HashEntry manager.
@@ -20,32 +20,32 @@ type HashEntry struct {
 	Value string `json:"value"`
 }
 
-func (r *ParseRdb) readHashMap(key KeyObject) error {
-	length, _, err := r.loadLen()
+func (d *Decoder) readHashMap(key KeyObject) error {
+	length, _, err := d.loadLen()
 	if err != nil {
 		return err
 	}
-	hashTable := HashMap{Field: key, Len: length, Entry: make([]HashEntry, 0, length)}
+	hashTable := HashMap{Field: key, Len: length, Entry: make([]HashEntry, 0, allocCap(length))}
 	for i := uint64(0); i < length; i++ {
-		field, err := r.loadString()
+		field, err := d.loadString()
 		if err != nil {
 			return err
 		}
-		value, err := r.loadString()
+		value, err := d.loadString()
 		if err != nil {
 			return err
 		}
 		hashTable.Entry = append(hashTable.Entry, HashEntry{Field: ToString(field), Value: ToString(value)})
 	}
-	r.d2 <- hashTable
-	return nil
+	return d.handler.OnHash(hashTable)
 }
 
-func (r *ParseRdb) readHashMapWithZipmap(key KeyObject) error {
-	zipmap, err := r.loadString()
+func (d *Decoder) readHashMapWithZipmap(key KeyObject) error {
+	zipmap, err := d.loadString()
 	if err != nil {
 		return err
 	}
+	key.pack(zipmap)
 	buf := newInput(zipmap)
 	blen, err := buf.ReadByte()
 	if err != nil {
@@ -73,15 +73,15 @@ func (r *ParseRdb) readHashMapWithZipmap(key KeyObject) error {
 		}
 		hashTable.Entry = append(hashTable.Entry, HashEntry{Field: ToString(field), Value: ToString(value)})
 	}
-	r.d2 <- hashTable
-	return nil
+	return d.handler.OnHash(hashTable)
 }
 
-func (r *ParseRdb) readHashMapZiplist(key KeyObject) error {
-	b, err := r.loadString()
+func (d *Decoder) readHashMapZiplist(key KeyObject) error {
+	b, err := d.loadString()
 	if err != nil {
 		return err
 	}
+	key.pack(b)
 	buf := newInput(b)
 	length, err := loadZiplistLength(buf)
 	if err != nil {
@@ -101,8 +101,28 @@ func (r *ParseRdb) readHashMapZiplist(key KeyObject) error {
 		}
 		hashTable.Entry = append(hashTable.Entry, HashEntry{Field: ToString(field), Value: ToString(value)})
 	}
-	r.d2 <- hashTable
-	return nil
+	return d.handler.OnHash(hashTable)
+}
+
+func (d *Decoder) readHashMapListPack(key KeyObject) error {
+	b, err := d.loadString()
+	if err != nil {
+		return err
+	}
+	key.pack(b)
+	items, err := loadListPack(b)
+	if err != nil {
+		return err
+	}
+	if len(items)%2 != 0 {
+		return errors.New("rdb: hash listpack has odd number of entries")
+	}
+
+	hashTable := HashMap{Field: key, Len: uint64(len(items) / 2), Entry: make([]HashEntry, 0, len(items)/2)}
+	for i := 0; i < len(items); i += 2 {
+		hashTable.Entry = append(hashTable.Entry, HashEntry{Field: ToString(items[i]), Value: ToString(items[i+1])})
+	}
+	return d.handler.OnHash(hashTable)
 }
 
 func (hm HashMap) Type() string {
@@ -110,11 +130,19 @@ func (hm HashMap) Type() string {
 }
 
 func (hm HashMap) String() string {
-	return fmt.Sprintf("{HashMap: {Key: %s, Len: %d, Entries: %s}}", hm.Key(), hm.Len, hm.Value())
+	return fmt.Sprintf("{HashMap: {Key: %s, Len: %d, Entries: %s}}", ToString(hm.Field), hm.Len, hm.Value())
 }
 
 func (hm HashMap) Key() string {
-	return ToString(hm.Field)
+	return hm.Field.Value()
+}
+
+func (hm HashMap) Meta() KeyObject {
+	return hm.Field
+}
+
+func (hm HashMap) Encoding() string {
+	return hm.Field.Encoding()
 }
 
 func (hm HashMap) Value() string {
@@ -131,10 +159,9 @@ func (hm HashMap) ValueLen() uint64 {
 
 // 计算 hash 结构 field + value 的大小
 func (hm HashMap) ConcreteSize() uint64 {
-	kv := make([]string, 0, len(hm.Entry))
-	for _, val := range hm.Entry {
-		tmp := val
-		kv = append(kv, tmp.Field+tmp.Value)
+	var size uint64
+	for _, entry := range hm.Entry {
+		size += uint64(len(entry.Field) + len(entry.Value))
 	}
-	return uint64(len(strings.Join(kv, "")))
+	return size
 }
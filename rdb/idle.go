@@ -0,0 +1,107 @@
+package rdb
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	coldestKeys   = 10
+	coldIdle      = 3600 // Keys idle for an hour at least are cold
+	coldFreq      = 1    // Keys with a LFU counter under or equal are cold
+	idleBucketNum = 7
+)
+
+var (
+	// Upper bounds (exclusive) in seconds of the idle histogram, the last bucket is unbounded.
+	idleBounds = []int64{60, 600, 3600, 86400, 7 * 86400, 30 * 86400}
+	idleLabels = []string{"< 1m", "< 10m", "< 1h", "< 1d", "< 7d", "< 30d", ">= 30d"}
+	// Upper bounds (inclusive) of the LFU counter distribution, the counter is at most 255.
+	freqBounds = []int64{0, 4, 9, 19, 49, 99, 255}
+	freqLabels = []string{"0", "1-4", "5-9", "10-19", "20-49", "50-99", "100-255"}
+)
+
+// IdleReport gathers the LRU idle time and LFU frequency of the keys,
+// only one of both is present according to the maxmemory-policy of the dump.
+type IdleReport struct {
+	IdleKeys  uint64
+	FreqKeys  uint64
+	IdleCount [idleBucketNum]uint64
+	IdleBytes [idleBucketNum]uint64
+	FreqCount [idleBucketNum]uint64
+	FreqBytes [idleBucketNum]uint64
+	Coldest   []ColdKey
+}
+
+type ColdKey struct {
+	Type string
+	Key  string
+	Size uint64
+	Idle int64
+	Freq int64
+}
+
+func (ir *IdleReport) Add(entity KeyRecord) {
+	meta := entity.Meta()
+	size := entity.ConcreteSize()
+	if meta.HasIdle() {
+		i := sort.Search(len(idleBounds), func(i int) bool { return meta.Idle < idleBounds[i] })
+		ir.IdleKeys++
+		ir.IdleCount[i]++
+		ir.IdleBytes[i] += size
+	}
+	if meta.HasFreq() {
+		i := sort.Search(len(freqBounds)-1, func(i int) bool { return meta.Freq <= freqBounds[i] })
+		ir.FreqKeys++
+		ir.FreqCount[i]++
+		ir.FreqBytes[i] += size
+	}
+	if (meta.HasIdle() && meta.Idle >= coldIdle) || (meta.HasFreq() && meta.Freq <= coldFreq) {
+		ir.addCold(ColdKey{Type: entity.Type(), Key: entity.Key(), Size: size, Idle: meta.Idle, Freq: meta.Freq})
+	}
+}
+
+// Keep the biggest cold keys, sorted by size descending.
+func (ir *IdleReport) addCold(k ColdKey) {
+	if len(ir.Coldest) == coldestKeys && ir.Coldest[len(ir.Coldest)-1].Size >= k.Size {
+		return
+	}
+	i := sort.Search(len(ir.Coldest), func(i int) bool { return ir.Coldest[i].Size < k.Size })
+	if len(ir.Coldest) < coldestKeys {
+		ir.Coldest = append(ir.Coldest, ColdKey{})
+	}
+	copy(ir.Coldest[i+1:], ir.Coldest[i:])
+	ir.Coldest[i] = k
+}
+
+func (ir *IdleReport) Empty() bool {
+	return ir.IdleKeys == 0 && ir.FreqKeys == 0
+}
+
+func (ir *IdleReport) Print() {
+	if ir.IdleKeys > 0 {
+		println("\n-------- LRU idle time -------\n")
+		for i, label := range idleLabels {
+			println(fmt.Sprintf("%-8s %d keys, %d bytes", label, ir.IdleCount[i], ir.IdleBytes[i]))
+		}
+	}
+	if ir.FreqKeys > 0 {
+		println("\n-------- LFU frequency -------\n")
+		for i, label := range freqLabels {
+			println(fmt.Sprintf("%-8s %d keys, %d bytes", label, ir.FreqCount[i], ir.FreqBytes[i]))
+		}
+	}
+	if len(ir.Coldest) > 0 {
+		println("\n-------- coldest big keys -------\n")
+		for _, k := range ir.Coldest {
+			info := ""
+			if k.Idle >= 0 {
+				info += fmt.Sprintf(" idle %ds", k.Idle)
+			}
+			if k.Freq >= 0 {
+				info += fmt.Sprintf(" freq %d", k.Freq)
+			}
+			println(fmt.Sprintf("%s '%s' %d bytes,%s", k.Type, k.Key, k.Size, info))
+		}
+	}
+}
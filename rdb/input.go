@@ -1,6 +1,7 @@
 package rdb
 
 import (
+	"bufio"
 	"errors"
 	"io"
 )
@@ -67,3 +68,83 @@ func (buf *input) Seek(offset int64, whence int) (int64, error) {
 	buf.index = int(abs)
 	return abs, nil
 }
+
+// offsetReader counts the bytes consumed from the RDB stream and their crc64.
+type offsetReader struct {
+	reader    *bufio.Reader
+	offset    int64
+	crc       uint64
+	capturing bool
+	capture   []byte // Bytes consumed since startCapture
+	scratch   []byte // Buffer of Discard
+}
+
+func newOffsetReader(reader *bufio.Reader) *offsetReader {
+	return &offsetReader{reader: reader}
+}
+
+func (r *offsetReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		r.offset++
+		r.crc = crc64Table[byte(r.crc)^b] ^ r.crc>>8
+		if r.capturing {
+			r.capture = append(r.capture, b)
+		}
+	}
+	return b, err
+}
+
+func (r *offsetReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.offset += int64(n)
+	r.crc = crc64Update(r.crc, b[:n])
+	if r.capturing {
+		r.capture = append(r.capture, b[:n]...)
+	}
+	return n, err
+}
+
+func (r *offsetReader) startCapture() {
+	r.capturing = true
+	r.capture = nil
+}
+
+// Stop capturing and return the bytes consumed since startCapture.
+func (r *offsetReader) stopCapture() []byte {
+	r.capturing = false
+	capture := r.capture
+	r.capture = nil
+	return capture
+}
+
+func (r *offsetReader) Offset() int64 {
+	return r.offset
+}
+
+// Crc64 of all the bytes consumed so far.
+func (r *offsetReader) Crc64() uint64 {
+	return r.crc
+}
+
+// Discard consumes n bytes, counted in the offset, the crc64 and the capture.
+func (r *offsetReader) Discard(n uint64) error {
+	if r.scratch == nil {
+		r.scratch = make([]byte, 4096)
+	}
+	for n > 0 {
+		chunk := r.scratch
+		if n < uint64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		read, err := io.ReadFull(r, chunk)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		n -= uint64(read)
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Redis type names of the records, as reported by the TYPE command.
+var redisTypes = map[string]string{
+	protocol.String:    "string",
+	protocol.List:      "list",
+	protocol.Set:       "set",
+	protocol.SortedSet: "zset",
+	protocol.Hash:      "hash",
+	protocol.Stream:    "stream",
+	protocol.Module:    "module",
+}
+
+// jsonWriter writes an array of records, one record by line without
+// buffering the whole dump. Keys are written with their database:
+//
+//	[
+//	{"type":"aux","key":"redis-ver","value":"7.0.0"},
+//	{"db":0,"key":"s","type":"string","encoding":"embstr","expire_at":null,"expire_ms":null,"idle":null,"freq":null,"value":"a","size":1,"memory":68}
+//	]
+type jsonWriter struct {
+	handler *bufio.Writer
+	records jsonRecords
+	count   uint64
+}
+
+func newJSONWriter(writer io.Writer) *jsonWriter {
+	return &jsonWriter{handler: bufio.NewWriter(writer)}
+}
+
+func (j *jsonWriter) Write(entity protocol.TypeObject, memory uint64) error {
+	record, err := j.records.encode(entity, memory)
+	if err != nil {
+		return err
+	}
+	if j.count == 0 {
+		j.handler.WriteString("[\n")
+	} else {
+		j.handler.WriteString(",\n")
+	}
+	j.count++
+	_, err = j.handler.Write(record)
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	if j.count == 0 {
+		j.handler.WriteString("[")
+	}
+	j.handler.WriteString("\n]\n")
+	return j.handler.Flush()
+}
+
+// ndjsonWriter writes a self-contained JSON object by line for every key,
+// the other records of the dump are only used to know the database.
+type ndjsonWriter struct {
+	handler *bufio.Writer
+	records jsonRecords
+}
+
+func newNDJSONWriter(writer io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{handler: bufio.NewWriter(writer)}
+}
+
+func (n *ndjsonWriter) Write(entity protocol.TypeObject, memory uint64) error {
+	// Encoded in any case, SelectDB changes the database of the following keys.
+	record, err := n.records.encode(entity, memory)
+	if err != nil {
+		return err
+	}
+	if _, ok := nonKeyTypes[entity.Type()]; ok && entity.Type() != protocol.Command {
+		return nil
+	}
+	n.handler.Write(record)
+	return n.handler.WriteByte('\n')
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return n.handler.Flush()
+}
+
+// jsonRecords encodes the records, the database of keys is the one of the last SelectDB.
+type jsonRecords struct {
+	db uint64
+}
+
+type jsonKey struct {
+	DB       uint64       `json:"db"`
+	Key      BinaryString `json:"key"`
+	Type     string       `json:"type"`
+	Encoding string       `json:"encoding"`
+	ExpireAt *string      `json:"expire_at"`
+	ExpireMs *int64       `json:"expire_ms"`
+	Idle     *int64       `json:"idle"`
+	Freq     *int64       `json:"freq"`
+	Value    interface{}  `json:"value"`
+	Size     uint64       `json:"size"`
+	Memory   uint64       `json:"memory"`
+}
+
+// jsonKeyEntry is a key of -keys-only, the size is the one of its serialized value.
+type jsonKeyEntry struct {
+	DB       uint64       `json:"db"`
+	Key      BinaryString `json:"key"`
+	Type     string       `json:"type"`
+	Encoding string       `json:"encoding"`
+	ExpireAt *string      `json:"expire_at"`
+	ExpireMs *int64       `json:"expire_ms"`
+	Size     uint64       `json:"size"`
+}
+
+type jsonAux struct {
+	Type  string       `json:"type"`
+	Key   BinaryString `json:"key"`
+	Value BinaryString `json:"value"`
+}
+
+type jsonSelectDB struct {
+	Type string `json:"type"`
+	DB   uint64 `json:"db"`
+}
+
+type jsonResizeDB struct {
+	Type        string `json:"type"`
+	DBSize      uint64 `json:"db_size"`
+	ExpiresSize uint64 `json:"expires_size"`
+}
+
+type jsonFunction struct {
+	Type        string       `json:"type"`
+	Engine      string       `json:"engine"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Code        BinaryString `json:"code"`
+}
+
+type jsonModuleAux struct {
+	Type   string `json:"type"`
+	Module string `json:"module"`
+	EncVer uint64 `json:"encver"`
+	When   uint64 `json:"when"`
+	Raw    string `json:"raw"`
+}
+
+type jsonModule struct {
+	Module string      `json:"module"`
+	EncVer uint64      `json:"encver"`
+	Value  interface{} `json:"value"`
+	Raw    string      `json:"raw,omitempty"`
+}
+
+type jsonMember struct {
+	Member BinaryString `json:"member"`
+	Score  jsonScore    `json:"score"`
+}
+
+type jsonStream struct {
+	Length       uint64            `json:"length"`
+	LastId       string            `json:"last_id"`
+	FirstId      string            `json:"first_id,omitempty"`
+	MaxDeletedId string            `json:"max_deleted_id,omitempty"`
+	EntriesAdded uint64            `json:"entries_added,omitempty"`
+	Entries      []jsonStreamEntry `json:"entries"`
+	Groups       []jsonStreamGroup `json:"groups"`
+}
+
+type jsonStreamEntry struct {
+	Id      string     `json:"id"`
+	Deleted bool       `json:"deleted,omitempty"`
+	Fields  jsonObject `json:"fields"`
+}
+
+type jsonStreamGroup struct {
+	Name        BinaryString         `json:"name"`
+	LastId      string               `json:"last_id"`
+	EntriesRead uint64               `json:"entries_read,omitempty"`
+	Pending     []jsonStreamPending  `json:"pending"`
+	Consumers   []jsonStreamConsumer `json:"consumers"`
+}
+
+type jsonStreamPending struct {
+	Id            string       `json:"id"`
+	Consumer      BinaryString `json:"consumer"`
+	DeliveryTime  uint64       `json:"delivery_time"`
+	DeliveryCount uint64       `json:"delivery_count"`
+}
+
+type jsonStreamConsumer struct {
+	Name       BinaryString `json:"name"`
+	SeenTime   uint64       `json:"seen_time"`
+	ActiveTime uint64       `json:"active_time,omitempty"`
+	Pending    int          `json:"pending"`
+}
+
+// encode returns the JSON of the record, without trailing newline.
+func (j *jsonRecords) encode(entity protocol.TypeObject, memory uint64) ([]byte, error) {
+	var record interface{}
+	switch v := entity.(type) {
+	case KeyEntry:
+		entry := jsonKeyEntry{DB: v.DB, Key: BinaryString(v.Key()), Type: redisTypes[v.Type()], Encoding: v.Encoding(), Size: v.Size}
+		entry.ExpireAt, entry.ExpireMs = jsonExpire(v.Field)
+		record = entry
+	case KeyRecord:
+		record = j.key(v, memory)
+	case AuxField:
+		record = jsonAux{Type: "aux", Key: BinaryString(v.Key()), Value: BinaryString(v.Value())}
+	case SelectionDB:
+		j.db = v.Index
+		record = jsonSelectDB{Type: "select_db", DB: v.Index}
+	case ResizeDB:
+		record = jsonResizeDB{Type: "resize_db", DBSize: v.DBSize, ExpiresSize: v.ExpireSize}
+	case FunctionLibrary:
+		record = jsonFunction{Type: "function", Engine: v.Engine, Name: v.Name, Description: v.Description, Code: BinaryString(v.Code)}
+	case ModuleAux:
+		record = jsonModuleAux{Type: "module_aux", Module: v.Module, EncVer: v.EncVer, When: v.When, Raw: hex.EncodeToString(v.Raw)}
+	case json.Marshaler:
+		record = v
+	default:
+		record = jsonAux{Type: entity.Type(), Key: BinaryString(entity.Key()), Value: BinaryString(entity.Value())}
+	}
+	return marshalJSON(record)
+}
+
+// The expire of the key in RFC3339 with its milliseconds and since epoch, nil without expire.
+func jsonExpire(meta KeyObject) (*string, *int64) {
+	if !meta.HasExpire() {
+		return nil, nil
+	}
+	expire, ms := meta.Expire.Format(time.RFC3339Nano), meta.ExpireMs()
+	return &expire, &ms
+}
+
+func (j *jsonRecords) key(entity KeyRecord, memory uint64) jsonKey {
+	meta := entity.Meta()
+	record := jsonKey{
+		DB:       j.db,
+		Key:      BinaryString(entity.Key()),
+		Type:     redisTypes[entity.Type()],
+		Encoding: entity.Encoding(),
+		Value:    jsonValue(entity),
+		Size:     entity.ConcreteSize(),
+		Memory:   memory,
+	}
+	record.ExpireAt, record.ExpireMs = jsonExpire(meta)
+	if meta.HasIdle() {
+		record.Idle = &meta.Idle
+	}
+	if meta.HasFreq() {
+		record.Freq = &meta.Freq
+	}
+	return record
+}
+
+// Typed value of the key: string, array of the items of lists and sets,
+// object of hashes and member/score pairs of sorted sets.
+func jsonValue(entity KeyRecord) interface{} {
+	switch v := entity.(type) {
+	case StringObject:
+		return BinaryString(v.Value())
+	case ListObject:
+		return binaryStrings(v.Entries)
+	case Set:
+		return binaryStrings(v.Entries)
+	case SortedSet:
+		members := make([]jsonMember, 0, len(v.Entries))
+		for _, entry := range v.Entries {
+			members = append(members, jsonMember{Member: BinaryString(ToString(entry.Field)), Score: jsonScore(entry.Score)})
+		}
+		return members
+	case HashMap:
+		fields := make(jsonObject, 0, len(v.Entry))
+		for _, entry := range v.Entry {
+			fields = append(fields, jsonPair{Key: entry.Field, Value: BinaryString(entry.Value)})
+		}
+		return fields
+	case RedisStream:
+		return jsonStreamValue(v)
+	case ModuleObject:
+		module := jsonModule{Module: v.Module, EncVer: v.EncVer, Value: v.Val}
+		if v.Val == nil {
+			module.Raw = hex.EncodeToString(v.Raw)
+		}
+		return module
+	}
+	return BinaryString(entity.Value())
+}
+
+func jsonStreamValue(rs RedisStream) jsonStream {
+	stream := jsonStream{Length: rs.Length, LastId: rs.LastId.String(), Entries: []jsonStreamEntry{}, Groups: []jsonStreamGroup{}}
+	if rs.EntriesAdded > 0 {
+		stream.FirstId = rs.FirstId.String()
+		stream.MaxDeletedId = rs.MaxDeletedId.String()
+		stream.EntriesAdded = rs.EntriesAdded
+	}
+	for _, message := range rs.Messages() {
+		fields := make(jsonObject, 0, len(message.Fields))
+		for _, field := range message.Fields {
+			fields = append(fields, jsonPair{Key: field.Field, Value: BinaryString(field.Value)})
+		}
+		stream.Entries = append(stream.Entries, jsonStreamEntry{Id: message.Id.String(), Deleted: message.Deleted, Fields: fields})
+	}
+	for _, g := range rs.Groups {
+		group := jsonStreamGroup{Name: BinaryString(g.Name), LastId: g.LastId, EntriesRead: g.EntriesRead, Pending: []jsonStreamPending{}, Consumers: []jsonStreamConsumer{}}
+		for _, p := range g.Pending() {
+			group.Pending = append(group.Pending, jsonStreamPending{Id: p.Id.String(), Consumer: BinaryString(p.Consumer), DeliveryTime: p.DeliveryTime, DeliveryCount: p.DeliveryCount})
+		}
+		for _, c := range g.Consumers {
+			group.Consumers = append(group.Consumers, jsonStreamConsumer{Name: BinaryString(c.Name), SeenTime: c.SeenTime, ActiveTime: c.ActiveTime, Pending: len(c.PendingEntryList)})
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream
+}
+
+func binaryStrings(items []string) []BinaryString {
+	values := make([]BinaryString, 0, len(items))
+	for _, item := range items {
+		values = append(values, BinaryString(item))
+	}
+	return values
+}
+
+// BinaryString is a redis string encoded as JSON string, the bytes of a
+// string which is not valid UTF-8 are written as the runes U+0000 to U+00FF.
+type BinaryString string
+
+func (b BinaryString) MarshalJSON() ([]byte, error) {
+	s := string(b)
+	if !utf8.ValidString(s) {
+		runes := make([]rune, 0, len(s))
+		for i := 0; i < len(s); i++ {
+			runes = append(runes, rune(s[i]))
+		}
+		s = string(runes)
+	}
+	return marshalJSON(s)
+}
+
+// jsonScore is a number, infinities and NaN which JSON lacks are strings: "inf", "-inf" and "nan".
+type jsonScore float64
+
+func (f jsonScore) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsInf(v, 1):
+		return []byte(`"inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-inf"`), nil
+	case math.IsNaN(v):
+		return []byte(`"nan"`), nil
+	}
+	return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+}
+
+// jsonObject keeps the order of its fields as saved in the dump, a repeated
+// field of a stream entry included.
+type jsonObject []jsonPair
+
+type jsonPair struct {
+	Key   string
+	Value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, pair := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSON(BinaryString(pair.Key))
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalJSON(pair.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Same as json.Marshal without escaping of HTML characters.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,36 @@
+package rdb
+
+import (
+	"testing"
+)
+
+// The fields of the stream entries are written as saved in the dump, a repeated field included.
+func TestJSONStreamFieldOrder(t *testing.T) {
+	tests := []struct {
+		fixture string
+		key     string
+		fields  []string // Of every entry
+	}{
+		{"dump-stream.rdb", "test", []string{`{"k":"v","k":"v"}`}},
+		{"dumpV9.rdb", "stream", []string{`{"name":"Lambert","age":"29"}`, `{"name":"Jack","age":"26"}`, `{"name":"Tom","age":"30"}`}},
+	}
+	for _, test := range tests {
+		rs, ok := decodeStreams(t, readFixture(t, test.fixture))[test.key]
+		if !ok {
+			t.Fatalf("%s: no stream %s", test.fixture, test.key)
+		}
+		entries := jsonStreamValue(rs).Entries
+		if len(entries) != len(test.fields) {
+			t.Fatalf("%s: %s has %d entries, want %d", test.fixture, test.key, len(entries), len(test.fields))
+		}
+		for i, entry := range entries {
+			fields, err := marshalJSON(entry.Fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(fields) != test.fields[i] {
+				t.Errorf("%s: %s entry %s has the fields %s, want %s", test.fixture, test.key, entry.Id, fields, test.fields[i])
+			}
+		}
+	}
+}
@@ -3,36 +3,182 @@ package rdb
 import (
 	"fmt"
 	"github.com/8090Lambert/go-redis-parser/protocol"
+	"strconv"
 	"time"
 )
 
+// NoExpire is the expire of the keys without expire, for NewKeyObject.
+const NoExpire int64 = -1
+
 type KeyObject struct {
 	Field  interface{}
-	Expire time.Time
+	Expire time.Time // Time of the expire, derived from its milliseconds, zero without expire
+	Idle   int64     // LRU idle time in seconds, -1 when the dump has no LRU info
+	Freq   int64     // LFU logarithmic counter, -1 when the dump has no LFU info
+
+	expireMs  int64 // Expire in milliseconds since epoch, as saved in the dump
+	hasExpire bool
+	encoding  string // Encoding of the value, as reported by OBJECT ENCODING once loaded
+	packed    uint64 // Bytes of the ziplists, listpacks or intset of the value, loaded as is
+	nodes     uint64 // Blobs of the value: quicklist nodes of a list, listpack nodes of a stream
+}
+
+// pack counts a ziplist, listpack or intset of the value, or a plain quicklist node.
+func (k *KeyObject) pack(blob []byte) {
+	k.packed += uint64(len(blob))
+	k.nodes++
+}
+
+// Encodings of the values by RDB type, strings depend on their value.
+var typeEncodings = map[byte]string{
+	TypeList:             "linkedlist",
+	TypeSet:              "hashtable",
+	TypeZset:             "skiplist",
+	TypeHash:             "hashtable",
+	TypeZset2:            "skiplist",
+	TypeModule:           "raw",
+	TypeModule2:          "raw",
+	TypeHashZipMap:       "zipmap",
+	TypeListZipList:      "ziplist",
+	TypeSetIntSet:        "intset",
+	TypeZsetZipList:      "ziplist",
+	TypeHashZipList:      "ziplist",
+	TypeListQuickList:    "quicklist",
+	TypeStreamListPacks:  "stream",
+	TypeHashListPack:     "listpack",
+	TypeZsetListPack:     "listpack",
+	TypeListQuickList2:   "quicklist",
+	TypeStreamListPacks2: "stream",
+	TypeSetListPack:      "listpack",
+	TypeStreamListPacks3: "stream",
+}
+
+// Record types of the values by RDB type, whatever their encoding.
+var objectTypes = map[byte]string{
+	TypeString:           protocol.String,
+	TypeList:             protocol.List,
+	TypeSet:              protocol.Set,
+	TypeZset:             protocol.SortedSet,
+	TypeHash:             protocol.Hash,
+	TypeZset2:            protocol.SortedSet,
+	TypeModule:           protocol.Module,
+	TypeModule2:          protocol.Module,
+	TypeHashZipMap:       protocol.Hash,
+	TypeListZipList:      protocol.List,
+	TypeSetIntSet:        protocol.Set,
+	TypeZsetZipList:      protocol.SortedSet,
+	TypeHashZipList:      protocol.Hash,
+	TypeListQuickList:    protocol.List,
+	TypeStreamListPacks:  protocol.Stream,
+	TypeHashListPack:     protocol.Hash,
+	TypeZsetListPack:     protocol.SortedSet,
+	TypeListQuickList2:   protocol.List,
+	TypeStreamListPacks2: protocol.Stream,
+	TypeSetListPack:      protocol.Set,
+	TypeStreamListPacks3: protocol.Stream,
+}
+
+// KeyInfo is a key as read from the dump, before its value.
+type KeyInfo struct {
+	DB   uint64
+	Key  KeyObject
+	Type byte // RDB type of the value
+}
+
+// TypeName returns the type of the value as the TYPE command names it: string, list, set, zset, hash, stream or module.
+func (k KeyInfo) TypeName() string {
+	return redisTypes[objectTypes[k.Type]]
+}
+
+// Strings up to this length are allocated with their object (embstr), since redis 3.2.
+const embstrSizeLimit = 44
+
+// Encoding of a string as redis chooses it when loading: int, embstr or raw.
+func stringEncoding(val []byte) string {
+	if _, ok := canonicalInt(val); ok {
+		return "int"
+	}
+	return lengthEncoding(uint64(len(val)))
 }
 
+// Encoding of a string which is not an integer.
+func lengthEncoding(length uint64) string {
+	if length <= embstrSizeLimit {
+		return "embstr"
+	}
+	return "raw"
+}
+
+// NewKeyObject returns the key expiring at the milliseconds since epoch, or
+// NoExpire. An expire of 0 or before 1970 is kept, the key has expired.
 func NewKeyObject(key interface{}, expire int64) KeyObject {
-	k := KeyObject{Field: key}
+	k := KeyObject{Field: key, Idle: -1, Freq: -1}
 
-	if expire > 0 {
-		k.Expire = time.Unix(expire/1000, 0).UTC()
+	if expire != NoExpire {
+		k.expireMs, k.hasExpire = expire, true
+		k.Expire = time.Unix(expire/1000, expire%1000*int64(time.Millisecond)).UTC()
 	}
 
 	return k
 }
 
+// Whether the key has an expire.
+func (k KeyObject) HasExpire() bool {
+	return k.hasExpire
+}
+
+// ExpireMs returns the expire of the key in milliseconds since epoch, NoExpire without expire.
+func (k KeyObject) ExpireMs() int64 {
+	if !k.hasExpire {
+		return NoExpire
+	}
+	return k.expireMs
+}
+
 // Whether the key has expired until now.
 func (k KeyObject) Expired() bool {
-	return k.Expire.Before(time.Now())
+	return k.ExpiredAt(time.Now())
+}
+
+// Whether the key has expired at the time, like the ctime of the dump. Never without expire.
+func (k KeyObject) ExpiredAt(t time.Time) bool {
+	return k.hasExpire && k.Expire.Before(t)
 }
 
 func (k KeyObject) Type() string {
 	return protocol.Key
 }
 
+// Encoding of the value as saved in the dump: int, embstr or raw strings,
+// ziplist, listpack, intset, zipmap, quicklist, linkedlist, hashtable,
+// skiplist, stream or raw modules. Empty when unknown.
+func (k KeyObject) Encoding() string {
+	return k.encoding
+}
+
+// Whether the dump carries a LRU idle time for the key.
+func (k KeyObject) HasIdle() bool {
+	return k.Idle >= 0
+}
+
+// Whether the dump carries a LFU frequency for the key.
+func (k KeyObject) HasFreq() bool {
+	return k.Freq >= 0
+}
+
 func (k KeyObject) String() string {
-	if !k.Expire.IsZero() {
-		return fmt.Sprintf("{ExpiryTime: %s, Key: %s}", k.Expire, k.Value())
+	var meta string
+	if k.hasExpire {
+		meta += fmt.Sprintf("ExpiryTime: %s, ", k.Expire)
+	}
+	if k.HasIdle() {
+		meta += fmt.Sprintf("Idle: %d, ", k.Idle)
+	}
+	if k.HasFreq() {
+		meta += fmt.Sprintf("Freq: %d, ", k.Freq)
+	}
+	if meta != "" {
+		return fmt.Sprintf("{%sKey: %s}", meta, k.Value())
 	}
 
 	return fmt.Sprintf("%s", k.Value())
@@ -54,3 +200,70 @@ func (k KeyObject) ValueLen() uint64 {
 func (k KeyObject) ConcreteSize() uint64 {
 	return uint64(len([]byte(k.Value())))
 }
+
+// KeyRecord is implemented by every record carrying a key, Meta returns
+// the key with its expiry, LRU and LFU info, Encoding the encoding of its value.
+type KeyRecord interface {
+	protocol.TypeObject
+	Meta() KeyObject
+	Encoding() string
+}
+
+// Columns of the key metadata for the gen-file, empty when missing:
+// the expire in RFC3339 with its milliseconds and since epoch, idle and freq.
+func (k KeyObject) columns() []string {
+	expire, expireMs, idle, freq := "", "", "", ""
+	if k.hasExpire {
+		expire = k.Expire.Format(time.RFC3339Nano)
+		expireMs = strconv.FormatInt(k.ExpireMs(), 10)
+	}
+	if k.HasIdle() {
+		idle = strconv.FormatInt(k.Idle, 10)
+	}
+	if k.HasFreq() {
+		freq = strconv.FormatInt(k.Freq, 10)
+	}
+	return []string{expire, expireMs, idle, freq}
+}
+
+// KeyEntry is a key reported without its value, which is skipped undecoded,
+// with the size of the value serialized in the dump.
+type KeyEntry struct {
+	Field   KeyObject
+	DB      uint64
+	RDBType byte   // RDB type of the value
+	Size    uint64 // Bytes of the serialized value
+}
+
+func (k KeyEntry) Type() string {
+	return objectTypes[k.RDBType]
+}
+
+func (k KeyEntry) String() string {
+	return fmt.Sprintf("{%s: {DB: %d, Key: %s, Size: %d}}", k.Type(), k.DB, ToString(k.Field), k.Size)
+}
+
+func (k KeyEntry) Key() string {
+	return k.Field.Value()
+}
+
+func (k KeyEntry) Meta() KeyObject {
+	return k.Field
+}
+
+func (k KeyEntry) Encoding() string {
+	return k.Field.Encoding()
+}
+
+func (k KeyEntry) Value() string {
+	return ""
+}
+
+// The serialized size, the number of elements is unknown without the value.
+func (k KeyEntry) ValueLen() uint64 {
+	return k.Size
+}
+
+func (k KeyEntry) ConcreteSize() uint64 {
+	return k.Size
+}
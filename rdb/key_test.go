@@ -0,0 +1,182 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// keyRecorder keeps the decoded keys by name.
+type keyRecorder struct {
+	NopHandler
+	keys  map[string]KeyRecord
+	order []string
+}
+
+func (r *keyRecorder) OnString(s StringObject) error {
+	if r.keys == nil {
+		r.keys = make(map[string]KeyRecord)
+	}
+	r.keys[s.Key()] = s
+	r.order = append(r.order, s.Key())
+	return nil
+}
+
+func decodeRecords(t *testing.T, dump []byte) *keyRecorder {
+	t.Helper()
+	r := &keyRecorder{}
+	if err := NewDecoder(bytes.NewReader(dump), r).Decode(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return r
+}
+
+type expectedExpire struct {
+	ms      int64
+	rfc3339 string
+}
+
+var fixtureExpires = map[string]map[string]expectedExpire{
+	"keys_with_expiry.rdb": {
+		"expires_ms_precision": {1671963072573, "2022-12-25T10:11:12.573Z"},
+	},
+	"keys_with_mixed_expiry.rdb": {
+		"key01": {2080245030932, "2035-12-02T21:50:30.932Z"},
+		"key02": {NoExpire, ""},
+		"key03": {NoExpire, ""},
+		"key04": {2080245034115, "2035-12-02T21:50:34.115Z"},
+	},
+}
+
+func TestKeyExpireMs(t *testing.T) {
+	for name, expires := range fixtureExpires {
+		r := decodeRecords(t, readFixture(t, name))
+		if len(r.keys) != len(expires) {
+			t.Fatalf("%s: %d keys, want %d", name, len(r.keys), len(expires))
+		}
+		for key, want := range expires {
+			meta := r.keys[key].Meta()
+			if meta.ExpireMs() != want.ms || meta.HasExpire() != (want.ms != NoExpire) {
+				t.Errorf("%s: %s expires at %d ms (%t), want %d", name, key, meta.ExpireMs(), meta.HasExpire(), want.ms)
+			}
+			if meta.HasExpire() && meta.Expire.Format(time.RFC3339Nano) != want.rfc3339 {
+				t.Errorf("%s: %s expires at %s, want %s", name, key, meta.Expire.Format(time.RFC3339Nano), want.rfc3339)
+			}
+		}
+	}
+}
+
+// The expire columns of the csv, json and memory gen-files.
+func TestKeyExpireColumns(t *testing.T) {
+	for name, expires := range fixtureExpires {
+		r := decodeRecords(t, readFixture(t, name))
+		for _, key := range r.order {
+			record, want := r.keys[key], expires[key]
+			wantMs := ""
+			if want.ms != NoExpire {
+				wantMs = ToString(want.ms)
+			}
+
+			var output bytes.Buffer
+			csvRecords := newCSVWriter(&output, false)
+			csvRecords.Write(record, 0)
+			csvRecords.Flush()
+			lines, err := csv.NewReader(&output).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expire, ms := lines[1][4], lines[1][5]; expire != want.rfc3339 || ms != wantMs {
+				t.Errorf("%s: csv %s expires at %q, %q ms, want %q, %q", name, key, expire, ms, want.rfc3339, wantMs)
+			}
+
+			output.Reset()
+			memoryRecords := newMemoryWriter(&output)
+			memoryRecords.Write(record, 0)
+			memoryRecords.Flush()
+			lines, err = csv.NewReader(&output).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expiry := lines[1][7]; expiry != want.rfc3339 {
+				t.Errorf("%s: memory %s expires at %q, want %q", name, key, expiry, want.rfc3339)
+			}
+
+			output.Reset()
+			ndjsonRecords := newNDJSONWriter(&output)
+			ndjsonRecords.Write(record, 0)
+			ndjsonRecords.Flush()
+			var object struct {
+				ExpireAt *string `json:"expire_at"`
+				ExpireMs *int64  `json:"expire_ms"`
+			}
+			if err := json.Unmarshal(output.Bytes(), &object); err != nil {
+				t.Fatal(err)
+			}
+			if want.ms == NoExpire {
+				if object.ExpireAt != nil || object.ExpireMs != nil {
+					t.Errorf("%s: json %s has an expire, want none", name, key)
+				}
+			} else if object.ExpireAt == nil || object.ExpireMs == nil || *object.ExpireAt != want.rfc3339 || *object.ExpireMs != want.ms {
+				t.Errorf("%s: json %s expire is %v, %v, want %q, %d", name, key, object.ExpireAt, object.ExpireMs, want.rfc3339, want.ms)
+			}
+		}
+	}
+}
+
+// secondsDump returns a RDB 2 dump of a string key expiring at the seconds, with EXPIRETIME.
+func secondsDump(key string, seconds int32) []byte {
+	var dump bytes.Buffer
+	dump.WriteString("REDIS0002")
+	dump.WriteByte(FlagOpcodeSelectDB)
+	dump.WriteByte(0)
+	dump.WriteByte(FlagOpcodeExpireTime)
+	binary.Write(&dump, binary.LittleEndian, seconds)
+	dump.WriteByte(TypeString)
+	dump.WriteByte(byte(len(key)))
+	dump.WriteString(key)
+	dump.WriteByte(1)
+	dump.WriteString("v")
+	dump.WriteByte(FlagOpcodeEOF)
+	return dump.Bytes()
+}
+
+// EXPIRETIME is a 32 bits integer of seconds, before RDB 3.
+func TestKeyExpireSeconds(t *testing.T) {
+	for _, seconds := range []int32{1671963072, 0, -60} {
+		r := decodeRecords(t, secondsDump("k", seconds))
+		meta := r.keys["k"].Meta()
+		if !meta.HasExpire() || meta.ExpireMs() != int64(seconds)*1000 {
+			t.Errorf("expire of %d s is %d ms (%t), want %d", seconds, meta.ExpireMs(), meta.HasExpire(), int64(seconds)*1000)
+		}
+	}
+}
+
+// An expire at 0 ms or before 1970 is an expire, the key has expired.
+func TestKeyExpireZero(t *testing.T) {
+	for _, ms := range []int64{0, -1500} {
+		k := StringObject{Field: NewKeyObject("k", ms), Val: "v"}
+		if !k.Field.HasExpire() || k.Field.ExpireMs() != ms || !k.Field.ExpiredAt(time.Now()) {
+			t.Errorf("key expiring at %d ms: %t, %d ms", ms, k.Field.HasExpire(), k.Field.ExpireMs())
+		}
+
+		var output bytes.Buffer
+		commands := newCommandWriter(&output, writeInline, commandBatch)
+		commands.Write(k, 0)
+		commands.Flush()
+		if want := "PEXPIREAT k " + ToString(ms); !bytes.Contains(output.Bytes(), []byte(want)) {
+			t.Errorf("commands of the key expiring at %d ms are %q, want %q", ms, output.String(), want)
+		}
+
+		report := ExpiryReport{ctime: time.Unix(1, 0)}
+		report.Add(TopKey{Key: "k", Memory: 60}, k.Field)
+		if db := report.DBs[0]; db.Expiring != 1 || db.TTLCount[expiredTTLIndex] != 1 || db.TTLMemory[expiredTTLIndex] != 60 {
+			t.Errorf("key expiring at %d ms is not counted as expired: %+v", ms, db)
+		}
+	}
+	if k := NewKeyObject("k", NoExpire); k.HasExpire() || k.ExpireMs() != NoExpire {
+		t.Errorf("key without expire has an expire at %d ms", k.ExpireMs())
+	}
+}
@@ -1,6 +1,7 @@
 package rdb
 
 import (
+	"errors"
 	"fmt"
 	"github.com/8090Lambert/go-redis-parser/protocol"
 	"strings"
@@ -12,47 +13,83 @@ type ListObject struct {
 	Entries []string
 }
 
-func (r *ParseRdb) readList(key KeyObject) error {
-	length, _, err := r.loadLen()
+func (d *Decoder) readList(key KeyObject) error {
+	length, _, err := d.loadLen()
 	if err != nil {
 		return err
 	}
-	listObj := ListObject{Field: key, Len: length, Entries: make([]string, 0, length)}
+	listObj := ListObject{Field: key, Len: length, Entries: make([]string, 0, allocCap(length))}
 	for i := uint64(0); i < length; i++ {
-		val, err := r.loadString()
+		val, err := d.loadString()
 		if err != nil {
 			return err
 		}
 		listObj.Entries = append(listObj.Entries, ToString(val))
 	}
-	r.d2 <- listObj
+	return d.handler.OnList(listObj)
+}
+
+func (d *Decoder) readListWithQuickList(key KeyObject) error {
+	length, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+
+	// Every quicklist node is a ziplist, collect all of them into one list.
+	listObj := ListObject{Field: key, Entries: make([]string, 0, allocCap(length))}
+	for i := uint64(0); i < length; i++ {
+		listItems, err := d.loadZipList(&listObj.Field)
+		if err != nil {
+			return err
+		}
+		for _, v := range listItems {
+			listObj.Entries = append(listObj.Entries, ToString(v))
+		}
+	}
+	listObj.Len = uint64(len(listObj.Entries))
 
-	return nil
+	return d.handler.OnList(listObj)
 }
 
-func (r *ParseRdb) readListWithQuickList(key KeyObject) error {
-	length, _, err := r.loadLen()
+// Since redis 7.0 quicklist nodes are listpacks, a big element is saved alone in a plain node.
+func (d *Decoder) readListWithQuickList2(key KeyObject) error {
+	length, _, err := d.loadLen()
 	if err != nil {
 		return err
 	}
 
+	listObj := ListObject{Field: key, Entries: make([]string, 0, allocCap(length))}
 	for i := uint64(0); i < length; i++ {
-		listItems, err := r.loadZipList()
+		container, _, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		b, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		listObj.Field.pack(b)
+		if container == QuickListNodePlain {
+			listObj.Entries = append(listObj.Entries, ToString(b))
+			continue
+		} else if container != QuickListNodePacked {
+			return errors.New(fmt.Sprintf("unknown quicklist node container: %d", container))
+		}
+		listItems, err := loadListPack(b)
 		if err != nil {
 			return err
 		}
-		listObj := ListObject{Field: key, Len: uint64(len(listItems)), Entries: make([]string, 0, len(listItems))}
 		for _, v := range listItems {
 			listObj.Entries = append(listObj.Entries, ToString(v))
 		}
-		r.d2 <- listObj
 	}
+	listObj.Len = uint64(len(listObj.Entries))
 
-	return nil
+	return d.handler.OnList(listObj)
 }
 
-func (r *ParseRdb) readListWithZipList(key KeyObject) error {
-	entries, err := r.loadZipList()
+func (d *Decoder) readListWithZipList(key KeyObject) error {
+	entries, err := d.loadZipList(&key)
 	if err != nil {
 		return err
 	}
@@ -60,16 +97,15 @@ func (r *ParseRdb) readListWithZipList(key KeyObject) error {
 	for _, v := range entries {
 		listObj.Entries = append(listObj.Entries, ToString(v))
 	}
-	r.d2 <- listObj
-
-	return nil
+	return d.handler.OnList(listObj)
 }
 
-func (r *ParseRdb) loadZipList() ([][]byte, error) {
-	b, err := r.loadString()
+func (d *Decoder) loadZipList(key *KeyObject) ([][]byte, error) {
+	b, err := d.loadString()
 	if err != nil {
 		return nil, err
 	}
+	key.pack(b)
 	buf := newInput(b)
 	length, err := loadZiplistLength(buf)
 	if err != nil {
@@ -93,11 +129,19 @@ func (l ListObject) Type() string {
 }
 
 func (l ListObject) String() string {
-	return fmt.Sprintf("{List: {Key: %s, Len: %d, Items: %s}}", l.Key(), l.Len, l.Value())
+	return fmt.Sprintf("{List: {Key: %s, Len: %d, Items: %s}}", ToString(l.Field), l.Len, l.Value())
 }
 
 func (l ListObject) Key() string {
-	return ToString(l.Field)
+	return l.Field.Value()
+}
+
+func (l ListObject) Meta() KeyObject {
+	return l.Field
+}
+
+func (l ListObject) Encoding() string {
+	return l.Field.Encoding()
 }
 
 func (l ListObject) Value() string {
@@ -0,0 +1,222 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Redis listpack, used by streams since 5.0 and by hash, zset, list and set since 7.0.
+// | total-bytes(4b) | num-elements(2b) | element_1 | ... | element_N | end(0xFF) |
+const (
+	ListPackHeaderSize = 6
+	ListPackEnd        = 0xFF
+	// num-elements holds 65535 when the listpack has more elements than it can store.
+	listPackUnknownNum = 65535
+)
+
+// Read all the entries of a listpack.
+func loadListPack(b []byte) ([][]byte, error) {
+	buf := newInput(b)
+	header, err := buf.Slice(ListPackHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	num := int(binary.LittleEndian.Uint16(header[4:]))
+	items := make([][]byte, 0, num)
+	for {
+		if buf.index < len(buf.data) && buf.data[buf.index] == ListPackEnd {
+			break
+		}
+		if num != listPackUnknownNum && len(items) == num {
+			return nil, errors.New("rdb: listpack has more elements than its header")
+		}
+		entry, err := loadListPackEntry(buf)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, entry)
+	}
+	if num != listPackUnknownNum && len(items) != num {
+		return nil, errors.New("rdb: listpack has less elements than its header")
+	}
+	return items, nil
+}
+
+// Read one listpack entry, integers are returned as their decimal string.
+func loadListPackEntry(buf *input) ([]byte, error) {
+	special, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, 0)
+	skip := 0
+	if special&0x80 == 0 {
+		skip = 1
+		res = []byte(strconv.FormatInt(int64(special&0x7F), 10))
+	} else if special&0xC0 == 0x80 {
+		length := special & 0x3F
+		skip = 1 + int(length)
+		res, err = buf.Slice(int(length))
+		if err != nil {
+			return nil, err
+		}
+	} else if special&0xE0 == 0xC0 {
+		skip = 2
+		next, err := buf.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		//int64(int32(uint32(special&0x1F)<<8|uint32(next)) << 19 >> 19)
+		res = []byte(strconv.FormatInt(int64(int32(uint32(special&0x1F)<<8|uint32(next))<<19>>19), 10))
+	} else if special&0xFF == 0xF1 {
+		skip = 3
+		b, err := buf.Slice(2)
+		if err != nil {
+			return nil, err
+		}
+		res = []byte(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10))
+	} else if special&0xFF == 0xF2 {
+		skip = 4
+		intBytes := make([]byte, 4)
+		_, err := buf.Read(intBytes[1:])
+		if err != nil {
+			return nil, err
+		}
+		res = []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(intBytes))>>8), 10))
+	} else if special&0xFF == 0xF3 {
+		skip = 5
+		intBytes, err := buf.Slice(4)
+		if err != nil {
+			return nil, err
+		}
+		res = []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(intBytes))), 10))
+	} else if special&0xFF == 0xF4 {
+		skip = 9
+		intBytes, err := buf.Slice(8)
+		if err != nil {
+			return nil, err
+		}
+		res = []byte(strconv.FormatInt(int64(binary.LittleEndian.Uint64(intBytes)), 10))
+	} else if special&0xF0 == 0xE0 {
+		b, err := buf.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length := int(special&0x0F)<<8 | int(b)
+		skip = 2 + int(length)
+		res, err = buf.Slice(int(length))
+		if err != nil {
+			return nil, err
+		}
+	} else if special&0xFF == 0xf0 {
+		lenBytes, err := buf.Slice(4)
+		if err != nil {
+			return nil, err
+		}
+		length := uint64(binary.LittleEndian.Uint32(lenBytes))
+		skip = 5 + int(length)
+		res, err = buf.Slice(int(length))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New(fmt.Sprintf("rdb: unknown listpack encoding byte: %d", special))
+	}
+
+	// element-total-len
+	if skip <= 127 {
+		buf.Seek(1, 1)
+	} else if skip < 16383 {
+		buf.Seek(2, 1)
+	} else if skip < 2097151 {
+		buf.Seek(3, 1)
+	} else if skip < 268435455 {
+		buf.Seek(4, 1)
+	} else {
+		buf.Seek(5, 1)
+	}
+	return res, err
+}
+
+// Build a listpack of the entries, the entries which are integers are stored as such.
+func buildListPack(entries [][]byte) []byte {
+	lp := make([]byte, ListPackHeaderSize, ListPackHeaderSize+len(entries)*2+1)
+	for _, entry := range entries {
+		lp = appendListPackEntry(lp, entry)
+	}
+	lp = append(lp, ListPackEnd)
+	binary.LittleEndian.PutUint32(lp, uint32(len(lp)))
+	num := len(entries)
+	if num > listPackUnknownNum {
+		num = listPackUnknownNum
+	}
+	binary.LittleEndian.PutUint16(lp[4:], uint16(num))
+	return lp
+}
+
+func appendListPackEntry(lp []byte, entry []byte) []byte {
+	start := len(lp)
+	if v, ok := canonicalInt(entry); ok {
+		switch {
+		case v >= 0 && v <= 127:
+			lp = append(lp, byte(v))
+		case v >= -4096 && v <= 4095:
+			u := uint16(v) & 0x1FFF
+			lp = append(lp, 0xC0|byte(u>>8), byte(u))
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			lp = append(lp, 0xF1, byte(v), byte(v>>8))
+		case v >= -1<<23 && v <= 1<<23-1:
+			lp = append(lp, 0xF2, byte(v), byte(v>>8), byte(v>>16))
+		case v >= math.MinInt32 && v <= math.MaxInt32:
+			lp = append(lp, 0xF3, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+		default:
+			lp = append(lp, 0xF4)
+			lp = append(lp, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(lp[len(lp)-8:], uint64(v))
+		}
+	} else {
+		length := len(entry)
+		switch {
+		case length < 64:
+			lp = append(lp, 0x80|byte(length))
+		case length < 4096:
+			lp = append(lp, 0xE0|byte(length>>8), byte(length))
+		default:
+			lp = append(lp, 0xF0, byte(length), byte(length>>8), byte(length>>16), byte(length>>24))
+		}
+		lp = append(lp, entry...)
+	}
+	return appendListPackBacklen(lp, uint64(len(lp)-start))
+}
+
+// The backlen stores the size of the entry from right to left, 7 bits by byte.
+func appendListPackBacklen(lp []byte, l uint64) []byte {
+	switch {
+	case l <= 127:
+		return append(lp, byte(l))
+	case l < 16383:
+		return append(lp, byte(l>>7), byte(l&127)|128)
+	case l < 2097151:
+		return append(lp, byte(l>>14), byte((l>>7)&127)|128, byte(l&127)|128)
+	case l < 268435455:
+		return append(lp, byte(l>>21), byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	}
+	return append(lp, byte(l>>28), byte((l>>21)&127)|128, byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+}
+
+// The integer value of a string, only when it is written exactly as redis
+// formats integers, "007" or "+1" are strings.
+func canonicalInt(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != string(b) {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,374 @@
+package rdb
+
+import (
+	"encoding/csv"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// MemoryProfile estimates the memory used by the keys once loaded by redis,
+// like the memory report of redis-rdb-tools: dict entries, objects, SDS
+// headers by length, packed encodings and jemalloc size classes. The
+// architecture and the version are the redis-bits and redis-ver aux fields
+// of the dump, 64 bits and redis 3.0 when the dump has none.
+type MemoryProfile struct {
+	pointer  uint64 // Size of a pointer, a long and a size_t
+	version  int    // Major * 100 + minor
+	skipNode uint64 // Expected size of a skiplist node, by its random level
+}
+
+// Sorted sets have a skiplist of 32 levels at most, a level more with probability 1/4.
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// Integers of 0 to 9999 are shared objects of redis.
+const sharedIntegers = 10000
+
+func NewMemoryProfile() *MemoryProfile {
+	p := &MemoryProfile{pointer: 8, version: 300}
+	p.skipNode = p.skiplistNode()
+	return p
+}
+
+// OnAux reads the architecture and the version of redis from the aux fields.
+func (p *MemoryProfile) OnAux(aux AuxField) {
+	switch aux.Key() {
+	case "redis-bits":
+		if arch, err := strconv.Atoi(aux.Value()); err == nil && (arch == 32 || arch == 64) {
+			p.pointer = uint64(arch / 8)
+			p.skipNode = p.skiplistNode()
+		}
+	case "redis-ver":
+		parts := strings.SplitN(aux.Value(), ".", 3)
+		if len(parts) < 2 {
+			return
+		}
+		major, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return
+		}
+		minor, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return
+		}
+		p.version = major*100 + minor
+	}
+}
+
+// Usage returns the bytes used by the key, its value and its expiry, 0 for the keys without value.
+func (p *MemoryProfile) Usage(record KeyRecord) uint64 {
+	meta := record.Meta()
+	var value uint64
+	switch v := record.(type) {
+	case StringObject:
+		value = p.stringObject(v.Value())
+	case ListObject:
+		value = p.list(meta, v.Entries)
+	case Set:
+		value = p.set(meta, v.Entries)
+	case SortedSet:
+		value = p.sortedSet(meta, v)
+	case HashMap:
+		value = p.hash(meta, v)
+	case RedisStream:
+		value = p.stream(meta, v)
+	case ModuleObject:
+		// The module keeps its own structure, its serialized value is the best guess.
+		value = p.robj() + p.malloc(uint64(len(v.Raw)))
+	default:
+		return 0
+	}
+	// The dict entry of the key in the keyspace and the slots of the table, 1.5 by entry on average.
+	usage := p.dictEntry() + p.pointer*3/2 + p.sds(uint64(len(meta.Value()))) + value
+	if meta.HasExpire() {
+		// The expire is the value of its entry in the expires dict.
+		usage += p.dictEntry() + p.pointer*3/2
+	}
+	return usage
+}
+
+// Top level value of a string: an object holding the integer, an embstr
+// allocated with its object, or a raw object and its SDS.
+func (p *MemoryProfile) stringObject(s string) uint64 {
+	if p.isLong(s) {
+		return p.robj()
+	}
+	if p.version >= 300 && uint64(len(s)) <= p.embstrLimit() {
+		return p.malloc(p.robjSize() + 3 + uint64(len(s)) + 1)
+	}
+	return p.robj() + p.sds(uint64(len(s)))
+}
+
+// Strings up to 39 bytes are embedded from redis 3.0, 44 bytes since the SDS header of 3.2.
+func (p *MemoryProfile) embstrLimit() uint64 {
+	if p.version >= 302 {
+		return embstrSizeLimit
+	}
+	return 39
+}
+
+// Element of a collection before redis 3.2, an object with its SDS, or the integer in a shared object.
+func (p *MemoryProfile) element(s string) uint64 {
+	if p.isLong(s) {
+		if n, _ := strconv.ParseInt(s, 10, 64); n >= 0 && n < sharedIntegers {
+			return 0
+		}
+		return p.robj()
+	}
+	return p.robj() + p.sds(uint64(len(s)))
+}
+
+func (p *MemoryProfile) list(meta KeyObject, entries []string) uint64 {
+	if meta.packed == 0 {
+		// Linked list of objects before redis 3.2.
+		usage := p.robj() + p.malloc(3*p.pointer+8)
+		for _, entry := range entries {
+			usage += p.malloc(3*p.pointer) + p.element(entry)
+		}
+		return usage
+	}
+	if meta.encoding == "ziplist" && p.version < 302 {
+		return p.robj() + p.blobs(meta)
+	}
+	// quicklist struct, then a node by ziplist or listpack and the blob itself.
+	return p.robj() + p.malloc(2*p.pointer+8+8) + meta.nodes*p.malloc(4*p.pointer+8+4) + p.blobs(meta)
+}
+
+func (p *MemoryProfile) set(meta KeyObject, entries []string) uint64 {
+	if meta.packed > 0 {
+		return p.robj() + p.blobs(meta)
+	}
+	usage := p.robj() + p.dict(uint64(len(entries)))
+	for _, entry := range entries {
+		usage += p.dictEntry() + p.collectionString(entry)
+	}
+	return usage
+}
+
+func (p *MemoryProfile) hash(meta KeyObject, hm HashMap) uint64 {
+	if meta.packed > 0 {
+		return p.robj() + p.blobs(meta)
+	}
+	usage := p.robj() + p.dict(uint64(len(hm.Entry)))
+	for _, entry := range hm.Entry {
+		usage += p.dictEntry() + p.collectionString(entry.Field) + p.collectionString(entry.Value)
+	}
+	return usage
+}
+
+func (p *MemoryProfile) sortedSet(meta KeyObject, zs SortedSet) uint64 {
+	if meta.packed > 0 {
+		return p.robj() + p.blobs(meta)
+	}
+	// zset struct, the dict and the skiplist with its header node of every level.
+	usage := p.robj() + p.malloc(2*p.pointer) + p.dict(uint64(len(zs.Entries)))
+	usage += p.malloc(2*p.pointer+8+4) + p.malloc(p.skiplistNodeSize(skiplistMaxLevel))
+	for _, entry := range zs.Entries {
+		// The member is shared by the dict and the skiplist, the dict points to the score of the node.
+		usage += p.dictEntry() + p.skipNode + p.collectionString(ToString(entry.Field))
+	}
+	return usage
+}
+
+// A rax of listpack nodes, the consumer groups with their pending entries and consumers.
+func (p *MemoryProfile) stream(meta KeyObject, rs RedisStream) uint64 {
+	// stream struct: rax, length, ids, entries added and groups, then the rax itself.
+	usage := p.robj() + p.malloc(p.pointer+8+16+16+16+8+p.pointer) + p.malloc(8+8+p.pointer)
+	// A rax node keyed by the master id of every listpack node.
+	usage += meta.nodes*p.raxNode(16) + p.blobs(meta)
+	for _, group := range rs.Groups {
+		// streamCG, its name in the rax of groups and the rax of its PEL.
+		usage += p.malloc(16+8+2*p.pointer) + p.raxNode(uint64(len(group.Name))) + p.malloc(8+8+p.pointer)
+		// streamNACK by pending entry, referenced by the PEL of the group and the PEL of its consumer.
+		usage += uint64(len(group.PendingEntryList)) * (p.malloc(8+8+p.pointer) + 2*p.raxNode(16))
+		for _, consumer := range group.Consumers {
+			usage += p.malloc(8+8+3*p.pointer) + p.sds(uint64(len(consumer.Name))) + p.raxNode(uint64(len(consumer.Name))) + p.malloc(8+8+p.pointer)
+		}
+	}
+	return usage
+}
+
+// Ziplists, listpacks and intsets are allocated as saved, one allocation by blob.
+func (p *MemoryProfile) blobs(meta KeyObject) uint64 {
+	if meta.nodes <= 1 {
+		return p.malloc(meta.packed)
+	}
+	return meta.nodes * p.malloc(meta.packed/meta.nodes)
+}
+
+// Strings of hashes, sets and sorted sets: SDS since redis 3.2, objects before.
+func (p *MemoryProfile) collectionString(s string) uint64 {
+	if p.version >= 302 {
+		return p.sds(uint64(len(s)))
+	}
+	return p.element(s)
+}
+
+// A leaf of the rax with its key and the pointer to its value, the inner nodes are shared.
+func (p *MemoryProfile) raxNode(key uint64) uint64 {
+	return p.malloc(4 + key + 2*p.pointer)
+}
+
+// dict struct with its two tables, the table of the next power of two of the size.
+func (p *MemoryProfile) dict(size uint64) uint64 {
+	usage := p.malloc(4 + 7*p.pointer + 4*p.pointer)
+	if size > 0 {
+		usage += p.malloc(nextPower(size) * p.pointer)
+	}
+	return usage
+}
+
+// dictEntry: key, value union and next pointers.
+func (p *MemoryProfile) dictEntry() uint64 {
+	return p.malloc(2*p.pointer + 8)
+}
+
+// redisObject: type, encoding and LRU in 4 bytes, the refcount and the pointer.
+func (p *MemoryProfile) robjSize() uint64 {
+	return 4 + 4 + p.pointer
+}
+
+func (p *MemoryProfile) robj() uint64 {
+	return p.malloc(p.robjSize())
+}
+
+// SDS of the length, its header fits the length since redis 3.2.
+func (p *MemoryProfile) sds(length uint64) uint64 {
+	if p.version < 302 {
+		return p.malloc(8 + length + 1)
+	}
+	header := uint64(17)
+	if length < 1<<5 {
+		header = 1
+	} else if length < 1<<8 {
+		header = 3
+	} else if length < 1<<16 {
+		header = 5
+	} else if length < 1<<32 {
+		header = 9
+	}
+	return p.malloc(header + length + 1)
+}
+
+// Integers of the long range are kept in the pointer of their object.
+func (p *MemoryProfile) isLong(s string) bool {
+	n, ok := canonicalInt([]byte(s))
+	if !ok {
+		return false
+	}
+	return p.pointer == 8 || (n >= -1<<31 && n < 1<<31)
+}
+
+// zskiplistNode of the level: member, score, backward, then forward and span by level.
+func (p *MemoryProfile) skiplistNodeSize(level int) uint64 {
+	return p.pointer + 8 + p.pointer + uint64(level)*(p.pointer+8)
+}
+
+// Expected allocation of a node, the level is random.
+func (p *MemoryProfile) skiplistNode() uint64 {
+	var expected, probability float64 = 0, 1 - skiplistP
+	for level := 1; level <= skiplistMaxLevel; level++ {
+		expected += probability * float64(p.malloc(p.skiplistNodeSize(level)))
+		probability *= skiplistP
+	}
+	return uint64(expected + 0.5)
+}
+
+// malloc returns the size class of jemalloc serving the allocation, redis
+// builds it with a quantum of 8 bytes: by 8 up to 64, by 16 up to 128, then 4
+// classes by doubling.
+func (p *MemoryProfile) malloc(size uint64) uint64 {
+	if size == 0 {
+		return 0
+	}
+	if size <= 64 {
+		return (size + 7) &^ 7
+	}
+	if size <= 128 {
+		return (size + 15) &^ 15
+	}
+	step := uint64(1) << uint(bits.Len64(size-1)-3)
+	return (size + step - 1) &^ (step - 1)
+}
+
+func nextPower(size uint64) uint64 {
+	power := uint64(4) // DICT_HT_INITIAL_SIZE
+	for power < size {
+		power *= 2
+	}
+	return power
+}
+
+// memoryWriter writes the memory report of the keys, with the columns of redis-rdb-tools:
+//
+//	database,type,key,size_in_bytes,encoding,num_elements,len_largest_element,expiry
+type memoryWriter struct {
+	handler *csv.Writer
+	db      uint64
+	header  bool
+}
+
+func newMemoryWriter(writer io.Writer) *memoryWriter {
+	return &memoryWriter{handler: csv.NewWriter(writer)}
+}
+
+func (m *memoryWriter) Write(entity protocol.TypeObject, memory uint64) error {
+	if !m.header {
+		m.handler.Write([]string{"database", "type", "key", "size_in_bytes", "encoding", "num_elements", "len_largest_element", "expiry"})
+		m.header = true
+	}
+	if db, ok := entity.(SelectionDB); ok {
+		m.db = db.Index
+	}
+	k, ok := entity.(KeyRecord)
+	if !ok {
+		return nil
+	}
+	meta := k.Meta()
+	return m.handler.Write([]string{
+		ToString(m.db), redisTypes[k.Type()], k.Key(), ToString(memory), k.Encoding(),
+		ToString(k.ValueLen()), ToString(largestElement(k)), meta.columns()[0],
+	})
+}
+
+func (m *memoryWriter) Flush() error {
+	m.handler.Flush()
+	return m.handler.Error()
+}
+
+// Bytes of the biggest element of the value: the string itself, an item, a member, a field or a value.
+func largestElement(record KeyRecord) uint64 {
+	var largest int
+	longest := func(s string) {
+		if len(s) > largest {
+			largest = len(s)
+		}
+	}
+	switch v := record.(type) {
+	case StringObject:
+		longest(v.Value())
+	case ListObject:
+		for _, entry := range v.Entries {
+			longest(entry)
+		}
+	case Set:
+		for _, entry := range v.Entries {
+			longest(entry)
+		}
+	case SortedSet:
+		for _, entry := range v.Entries {
+			longest(ToString(entry.Field))
+		}
+	case HashMap:
+		for _, entry := range v.Entry {
+			longest(entry.Field)
+			longest(entry.Value)
+		}
+	}
+	return uint64(largest)
+}
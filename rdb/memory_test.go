@@ -0,0 +1,58 @@
+package rdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// listProfiler estimates the memory of the lists of a dump.
+type listProfiler struct {
+	NopHandler
+	profile *MemoryProfile
+	lists   map[string]ListObject
+}
+
+func (l *listProfiler) OnAux(aux AuxField) error {
+	l.profile.OnAux(aux)
+	return nil
+}
+
+func (l *listProfiler) OnList(list ListObject) error {
+	l.lists[list.Key()] = list
+	return nil
+}
+
+// The nodes of a quicklist are kept with the list, a node and its blob by ziplist or listpack.
+func TestMemoryQuickList(t *testing.T) {
+	tests := []struct {
+		fixture string
+		key     string
+		packed  uint64
+		nodes   uint64
+		usage   uint64
+	}{
+		// dictEntry 24, slots 12, sds 8, robj 16, quicklist 32, node 48, listpack of 26 bytes 32.
+		{"rdb_v7_list_quicklist.rdb", "foo", 26, 1, 172},
+		// The same with a ziplist of 13 bytes, 16.
+		{"dumpV8.rdb", "rpush", 13, 1, 156},
+		// A ziplist of 41 bytes, 48.
+		{"dump-4.0.10.rdb", "list", 41, 1, 188},
+	}
+	for _, test := range tests {
+		l := &listProfiler{profile: NewMemoryProfile(), lists: make(map[string]ListObject)}
+		if err := NewDecoder(bytes.NewReader(readFixture(t, test.fixture)), l).Decode(); err != nil {
+			t.Fatalf("%s: decode: %v", test.fixture, err)
+		}
+		list, ok := l.lists[test.key]
+		if !ok {
+			t.Fatalf("%s: no list %s", test.fixture, test.key)
+		}
+		if meta := list.Meta(); meta.Encoding() != "quicklist" || meta.packed != test.packed || meta.nodes != test.nodes {
+			t.Errorf("%s: %s is %s of %d bytes in %d nodes, want quicklist of %d bytes in %d nodes",
+				test.fixture, test.key, meta.Encoding(), meta.packed, meta.nodes, test.packed, test.nodes)
+		}
+		if usage := l.profile.Usage(list); usage != test.usage {
+			t.Errorf("%s: %s uses %d bytes, want %d", test.fixture, test.key, usage, test.usage)
+		}
+	}
+}
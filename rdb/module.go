@@ -0,0 +1,305 @@
+package rdb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"math"
+	"sync"
+)
+
+const (
+	// Since RDB_TYPE_MODULE_2 every value saved by a module is preceded by its opcode.
+	ModuleOpcodeEOF    = 0 /* End of module value. */
+	ModuleOpcodeSInt   = 1
+	ModuleOpcodeUInt   = 2
+	ModuleOpcodeFloat  = 3
+	ModuleOpcodeDouble = 4
+	ModuleOpcodeString = 5
+
+	// 64-bit module ID: 9 characters of 6 bits for the type name, and 10 bits of encoding version.
+	moduleEncVerBits = 10
+	moduleEncVerMask = 1<<moduleEncVerBits - 1
+	moduleNameCset   = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// ModuleDecoder decodes a value of a module type, like the rdb_load callback of the module.
+type ModuleDecoder func(r *ModuleReader, encver uint64) (interface{}, error)
+
+var (
+	modulesMu sync.RWMutex
+	modules   = make(map[uint64]ModuleDecoder)
+)
+
+// RegisterModule registers the decoder of a module type by its 64-bit module ID (see ModuleTypeID),
+// the encoding version bits of the ID are ignored, the decoder receives them instead.
+func RegisterModule(id uint64, decoder ModuleDecoder) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	modules[id&^moduleEncVerMask] = decoder
+}
+
+func lookupModule(id uint64) ModuleDecoder {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	return modules[id&^moduleEncVerMask]
+}
+
+// ModuleTypeID returns the module ID of a 9 characters type name, for example "ReJSON-RL", with encoding version 0.
+func ModuleTypeID(name string) (uint64, error) {
+	if len(name) != 9 {
+		return 0, errors.New("module type name must be 9 characters: " + name)
+	}
+	var id uint64
+	for i := 0; i < len(name); i++ {
+		pos := -1
+		for j := 0; j < len(moduleNameCset); j++ {
+			if moduleNameCset[j] == name[i] {
+				pos = j
+				break
+			}
+		}
+		if pos < 0 {
+			return 0, errors.New("invalid character in module type name: " + name)
+		}
+		id = id<<6 | uint64(pos)
+	}
+	return id << moduleEncVerBits, nil
+}
+
+// ModuleTypeName returns the type name and the encoding version saved in a module ID.
+func ModuleTypeName(id uint64) (string, uint64) {
+	encver := id & moduleEncVerMask
+	id >>= moduleEncVerBits
+	name := make([]byte, 9)
+	for i := 8; i >= 0; i-- {
+		name[i] = moduleNameCset[id&63]
+		id >>= 6
+	}
+	return string(name), encver
+}
+
+// ModuleReader reads the values saved by a module, like the RedisModule_Load* API.
+type ModuleReader struct {
+	d      *Decoder
+	framed bool // RDB_TYPE_MODULE_2 and module aux, values are preceded by their opcode
+}
+
+func (m *ModuleReader) expect(opcode uint64) error {
+	if !m.framed {
+		return nil
+	}
+	code, _, err := m.d.loadLen()
+	if err != nil {
+		return err
+	}
+	if code != opcode {
+		return errors.New(fmt.Sprintf("module value opcode %d, expected %d", code, opcode))
+	}
+	return nil
+}
+
+func (m *ModuleReader) LoadUnsigned() (uint64, error) {
+	if err := m.expect(ModuleOpcodeUInt); err != nil {
+		return 0, err
+	}
+	v, _, err := m.d.loadLen()
+	return v, err
+}
+
+func (m *ModuleReader) LoadSigned() (int64, error) {
+	if err := m.expect(ModuleOpcodeSInt); err != nil {
+		return 0, err
+	}
+	v, _, err := m.d.loadLen()
+	return int64(v), err
+}
+
+func (m *ModuleReader) LoadString() ([]byte, error) {
+	if err := m.expect(ModuleOpcodeString); err != nil {
+		return nil, err
+	}
+	return m.d.loadString()
+}
+
+func (m *ModuleReader) LoadDouble() (float64, error) {
+	if err := m.expect(ModuleOpcodeDouble); err != nil {
+		return 0, err
+	}
+	return m.d.loadBinaryFloat()
+}
+
+func (m *ModuleReader) LoadFloat() (float32, error) {
+	if err := m.expect(ModuleOpcodeFloat); err != nil {
+		return 0, err
+	}
+	v, err := m.d.loadUint32()
+	return math.Float32frombits(v), err
+}
+
+// Walk the opcode framing of a value until the EOF opcode, without understanding it.
+func (m *ModuleReader) skip() error {
+	for {
+		opcode, _, err := m.d.loadLen()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case ModuleOpcodeEOF:
+			return nil
+		case ModuleOpcodeSInt, ModuleOpcodeUInt:
+			_, _, err = m.d.loadLen()
+		case ModuleOpcodeFloat:
+			_, err = m.d.loadUint32()
+		case ModuleOpcodeDouble:
+			_, err = m.d.loadBinaryFloat()
+		case ModuleOpcodeString:
+			err = m.d.skipString()
+		default:
+			return errors.New(fmt.Sprintf("unknown module opcode %d", opcode))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Value of a module type, for example a RedisJSON document or a RedisBloom filter.
+type ModuleObject struct {
+	Field  KeyObject
+	Module string      // Module type name, for example "ReJSON-RL"
+	EncVer uint64      // Encoding version of the value
+	Val    interface{} // Value returned by the registered ModuleDecoder, nil without decoder
+	Raw    []byte      // Serialized value, only kept for RDB_TYPE_MODULE_2
+}
+
+func (d *Decoder) readModule(key KeyObject, t byte) error {
+	id, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	name, encver := ModuleTypeName(id)
+	module := ModuleObject{Field: key, Module: name, EncVer: encver}
+	reader := &ModuleReader{d: d, framed: t == TypeModule2}
+	decoder := lookupModule(id)
+	if decoder == nil && t == TypeModule {
+		return errors.New("module " + name + " saved without opcodes needs a registered decoder")
+	}
+
+	if reader.framed && !(decoder == nil && d.SkipUnknownModules) {
+		d.input.startCapture()
+	}
+	if decoder != nil {
+		if module.Val, err = decoder(reader, encver); err == nil && reader.framed {
+			err = reader.expect(ModuleOpcodeEOF)
+		}
+	} else {
+		err = reader.skip()
+	}
+	module.Raw = d.input.stopCapture()
+	if err != nil {
+		return errors.New("Parse module " + name + " failed: " + err.Error())
+	}
+
+	if decoder == nil && d.SkipUnknownModules {
+		return nil
+	}
+	return d.handler.OnModule(module)
+}
+
+func (m ModuleObject) Type() string {
+	return protocol.Module
+}
+
+func (m ModuleObject) String() string {
+	return fmt.Sprintf("{Module: {Key: %s, Module: %s, Value: %s}}", ToString(m.Field), m.Module, m.Value())
+}
+
+func (m ModuleObject) Key() string {
+	return m.Field.Value()
+}
+
+func (m ModuleObject) Meta() KeyObject {
+	return m.Field
+}
+
+func (m ModuleObject) Encoding() string {
+	return m.Field.Encoding()
+}
+
+// The decoded value, or the hex dump of the serialized value without decoder.
+func (m ModuleObject) Value() string {
+	if m.Val == nil {
+		return hex.EncodeToString(m.Raw)
+	}
+	if s, ok := m.Val.(string); ok {
+		return s
+	}
+	output, err := json.Marshal(m.Val)
+	if err != nil {
+		return ToString(m.Val)
+	}
+	return string(output)
+}
+
+func (m ModuleObject) ValueLen() uint64 {
+	return uint64(len(m.Raw))
+}
+
+func (m ModuleObject) ConcreteSize() uint64 {
+	return uint64(len(m.Raw))
+}
+
+// Auxiliary data saved by a module outside of the keys (RDB_OPCODE_MODULE_AUX).
+type ModuleAux struct {
+	Module string // Module type name
+	EncVer uint64
+	When   uint64 // REDISMODULE_AUX_BEFORE_RDB or REDISMODULE_AUX_AFTER_RDB
+	Raw    []byte // Serialized data, always opcode framed
+}
+
+func (d *Decoder) readModuleAux() error {
+	id, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	name, encver := ModuleTypeName(id)
+	reader := &ModuleReader{d: d, framed: true}
+	when, err := reader.LoadUnsigned()
+	if err != nil {
+		return err
+	}
+	d.input.startCapture()
+	err = reader.skip()
+	raw := d.input.stopCapture()
+	if err != nil {
+		return errors.New("Parse module " + name + " aux failed: " + err.Error())
+	}
+	return d.handler.OnModuleAux(ModuleAux{Module: name, EncVer: encver, When: when, Raw: raw})
+}
+
+func (ma ModuleAux) Type() string {
+	return protocol.ModuleAux
+}
+
+func (ma ModuleAux) String() string {
+	return fmt.Sprintf("{ModuleAux: {Module: %s, When: %d, Value: %s}}", ma.Module, ma.When, ma.Value())
+}
+
+func (ma ModuleAux) Key() string {
+	return ma.Module
+}
+
+func (ma ModuleAux) Value() string {
+	return hex.EncodeToString(ma.Raw)
+}
+
+func (ma ModuleAux) ValueLen() uint64 {
+	return 0
+}
+
+func (ma ModuleAux) ConcreteSize() uint64 {
+	return 0
+}
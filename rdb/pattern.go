@@ -0,0 +1,151 @@
+package rdb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Distinct patterns kept at most, the keys of the next ones are counted into otherPattern.
+const maxKeyPatterns = 10000
+
+const otherPattern = "{other}"
+
+// Placeholders spanning separators, replaced before the segments are looked at.
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	uuidPattern  = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	datePattern  = regexp.MustCompile(`\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?)?`)
+)
+
+// Hex segments shorter than this are words, like "cafe" or "add".
+const minHexLength = 8
+
+// KeyPattern aggregates the keys of an inferred pattern, like "user:{int}:profile".
+type KeyPattern struct {
+	Pattern  string
+	Example  string // First key of the pattern
+	Keys     uint64
+	Bytes    uint64 // Payload of the values
+	Memory   uint64 // Estimated memory of the keys
+	Elements uint64 // Elements of the values
+}
+
+// KeyPatterns groups the keys by pattern in a single pass, the number of
+// patterns is bounded whatever the cardinality of the keyspace.
+type KeyPatterns struct {
+	patterns map[string]*KeyPattern
+	other    KeyPattern
+}
+
+func NewKeyPatterns() *KeyPatterns {
+	return &KeyPatterns{patterns: make(map[string]*KeyPattern), other: KeyPattern{Pattern: otherPattern}}
+}
+
+func (p *KeyPatterns) Add(key string, bytes, memory, elements uint64) {
+	pattern := InferPattern(key)
+	stats, ok := p.patterns[pattern]
+	if !ok {
+		if len(p.patterns) < maxKeyPatterns {
+			stats = &KeyPattern{Pattern: pattern, Example: key}
+			p.patterns[pattern] = stats
+		} else {
+			stats = &p.other
+			if stats.Example == "" {
+				stats.Example = key
+			}
+		}
+	}
+	stats.Keys++
+	stats.Bytes += bytes
+	stats.Memory += memory
+	stats.Elements += elements
+}
+
+// Top returns the n biggest patterns by memory, by bytes when the memory is unknown.
+func (p *KeyPatterns) Top(n int) []KeyPattern {
+	patterns := make([]KeyPattern, 0, len(p.patterns)+1)
+	for _, stats := range p.patterns {
+		patterns = append(patterns, *stats)
+	}
+	if p.other.Keys > 0 {
+		patterns = append(patterns, p.other)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Memory != patterns[j].Memory {
+			return patterns[i].Memory > patterns[j].Memory
+		}
+		if patterns[i].Bytes != patterns[j].Bytes {
+			return patterns[i].Bytes > patterns[j].Bytes
+		}
+		return patterns[i].Pattern < patterns[j].Pattern
+	})
+	if len(patterns) > n {
+		patterns = patterns[:n]
+	}
+	return patterns
+}
+
+func (p *KeyPatterns) Print(n int) {
+	println(fmt.Sprintf("\n-------- top %d key patterns -------\n", n))
+	for i, stats := range p.Top(n) {
+		println(fmt.Sprintf("%2d. '%s' %d keys, %d bytes, %d bytes of memory, %d elements, like '%s'",
+			i+1, stats.Pattern, stats.Keys, stats.Bytes, stats.Memory, stats.Elements, stats.Example))
+	}
+	if p.other.Keys > 0 {
+		println(fmt.Sprintf("\nMore than %d patterns, the keys of the next ones are counted into '%s'", maxKeyPatterns, otherPattern))
+	}
+}
+
+// InferPattern replaces the variable parts of the key with placeholders:
+// {email}, {uuid} and {date}, then the segments between non alphanumeric
+// characters which are {int} or {hex}. "user:42:profile" is "user:{int}:profile".
+func InferPattern(key string) string {
+	key = emailPattern.ReplaceAllLiteralString(key, "{email}")
+	key = uuidPattern.ReplaceAllLiteralString(key, "{uuid}")
+	key = datePattern.ReplaceAllLiteralString(key, "{date}")
+
+	var pattern strings.Builder
+	pattern.Grow(len(key))
+	start := -1
+	for i := 0; i <= len(key); i++ {
+		if i < len(key) && isAlphanumeric(key[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			pattern.WriteString(segmentPattern(key[start:i]))
+			start = -1
+		}
+		if i < len(key) {
+			pattern.WriteByte(key[i])
+		}
+	}
+	return pattern.String()
+}
+
+func segmentPattern(segment string) string {
+	digits, hex := 0, 0
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if c >= '0' && c <= '9' {
+			digits++
+		} else if (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			hex++
+		}
+	}
+	if digits == len(segment) {
+		return "{int}"
+	}
+	if digits+hex == len(segment) && digits > 0 && len(segment) >= minHexLength {
+		return "{hex}"
+	}
+	return segment
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
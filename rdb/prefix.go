@@ -0,0 +1,167 @@
+package rdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Children kept at most by prefix, the keys of the next ones are counted into
+// the child {other}, like "session:{other}", without longer prefixes.
+const maxPrefixChildren = 1000
+
+// PrefixNode aggregates the keys starting with its prefix, like "svc:entity:".
+type PrefixNode struct {
+	Prefix   string
+	Keys     uint64
+	Expiring uint64 // Keys with an expire
+	Bytes    uint64 // Payload of the values
+	Memory   uint64 // Estimated memory of the keys
+	Elements uint64 // Elements of the values
+
+	children map[string]*PrefixNode
+	other    *PrefixNode // Keys of the children over maxPrefixChildren
+}
+
+// ExpiringRatio returns the share of the keys with an expire, from 0 to 1.
+func (n *PrefixNode) ExpiringRatio() float64 {
+	if n.Keys == 0 {
+		return 0
+	}
+	return float64(n.Expiring) / float64(n.Keys)
+}
+
+func (n *PrefixNode) add(bytes, memory, elements uint64, expiring bool) {
+	n.Keys++
+	n.Bytes += bytes
+	n.Memory += memory
+	n.Elements += elements
+	if expiring {
+		n.Expiring++
+	}
+}
+
+// Children returns the nodes of the longer prefixes, the biggest memory first.
+func (n *PrefixNode) Children() []*PrefixNode {
+	children := make([]*PrefixNode, 0, len(n.children)+1)
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	if n.other != nil {
+		children = append(children, n.other)
+	}
+	sortPrefixes(children)
+	return children
+}
+
+// PrefixTrie splits the keys at the delimiters into prefixes of depth segments
+// at most. The last segment of a key is not a prefix, "user:42" counts in "user:".
+type PrefixTrie struct {
+	Root       *PrefixNode // Every key, with an empty prefix
+	delimiters string
+	depth      int
+	folded     bool // A prefix has more than maxPrefixChildren children
+}
+
+func NewPrefixTrie(delimiters string, depth int) *PrefixTrie {
+	return &PrefixTrie{Root: &PrefixNode{children: make(map[string]*PrefixNode)}, delimiters: delimiters, depth: depth}
+}
+
+// Add counts the key into the root and every prefix of the key.
+func (t *PrefixTrie) Add(key string, bytes, memory, elements uint64, expiring bool) {
+	node := t.Root
+	node.add(bytes, memory, elements, expiring)
+	start := 0
+	for level := 0; level < t.depth; level++ {
+		i := strings.IndexAny(key[start:], t.delimiters)
+		if i < 0 {
+			return
+		}
+		start += i + 1
+		segment := key[:start]
+		child, ok := node.children[segment]
+		if !ok && len(node.children) >= maxPrefixChildren {
+			if node.other == nil {
+				node.other = &PrefixNode{Prefix: node.Prefix + otherPattern}
+				t.folded = true
+			}
+			node.other.add(bytes, memory, elements, expiring)
+			return
+		}
+		if !ok {
+			child = &PrefixNode{Prefix: segment, children: make(map[string]*PrefixNode)}
+			node.children[segment] = child
+		}
+		child.add(bytes, memory, elements, expiring)
+		node = child
+	}
+}
+
+// Top returns the n biggest prefixes of any depth by memory, by bytes when the memory is unknown.
+func (t *PrefixTrie) Top(n int) []*PrefixNode {
+	prefixes := make([]*PrefixNode, 0)
+	var walk func(node *PrefixNode)
+	walk = func(node *PrefixNode) {
+		for _, child := range node.children {
+			prefixes = append(prefixes, child)
+			walk(child)
+		}
+		if node.other != nil {
+			prefixes = append(prefixes, node.other)
+		}
+	}
+	walk(t.Root)
+	sortPrefixes(prefixes)
+	if len(prefixes) > n {
+		prefixes = prefixes[:n]
+	}
+	return prefixes
+}
+
+func sortPrefixes(prefixes []*PrefixNode) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Memory != prefixes[j].Memory {
+			return prefixes[i].Memory > prefixes[j].Memory
+		}
+		if prefixes[i].Bytes != prefixes[j].Bytes {
+			return prefixes[i].Bytes > prefixes[j].Bytes
+		}
+		return prefixes[i].Prefix < prefixes[j].Prefix
+	})
+}
+
+// Print writes the top prefixes, then the tree of the prefixes with n children at most by node.
+func (t *PrefixTrie) Print(n int) {
+	println(fmt.Sprintf("\n-------- top %d key prefixes -------\n", n))
+	for i, node := range t.Top(n) {
+		println(fmt.Sprintf("%2d. '%s' %s", i+1, node.Prefix, node.stats()))
+	}
+
+	println("\n-------- key prefixes tree -------\n")
+	println(fmt.Sprintf("* %s", t.Root.stats()))
+	t.Root.printChildren("", n)
+	if t.folded {
+		println(fmt.Sprintf("\nMore than %d children by prefix, the keys of the next ones are counted into '%s'", maxPrefixChildren, otherPattern))
+	}
+}
+
+func (n *PrefixNode) printChildren(indent string, max int) {
+	children := n.Children()
+	for i, child := range children {
+		if i == max {
+			println(fmt.Sprintf("%s└── ... %d more prefixes", indent, len(children)-max))
+			return
+		}
+		branch, next := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, next = "└── ", "    "
+		}
+		println(fmt.Sprintf("%s%s'%s' %s", indent, branch, child.Prefix, child.stats()))
+		child.printChildren(indent+next, max)
+	}
+}
+
+func (n *PrefixNode) stats() string {
+	return fmt.Sprintf("%d keys, %d bytes, %d bytes of memory, %d elements, %.1f%% expiring",
+		n.Keys, n.Bytes, n.Memory, n.Elements, n.ExpiringRatio()*100)
+}
@@ -0,0 +1,35 @@
+package rdb
+
+import (
+	"strconv"
+	"testing"
+)
+
+// The children of a prefix are bounded, whatever the number of ids in the keys.
+func TestPrefixTrieFoldsChildren(t *testing.T) {
+	trie := NewPrefixTrie(":", 3)
+	for i := 0; i < maxPrefixChildren+500; i++ {
+		trie.Add("session:"+strconv.Itoa(i)+":data", 10, 100, 1, false)
+	}
+	// A known prefix keeps counting its keys once the children are folded.
+	trie.Add("session:0:data", 10, 100, 1, true)
+
+	root := trie.Root.Children()
+	if len(root) != 1 || root[0].Prefix != "session:" || root[0].Keys != maxPrefixChildren+501 {
+		t.Fatalf("root children are %+v, want session: of %d keys", root, maxPrefixChildren+501)
+	}
+	children := root[0].Children()
+	if len(children) != maxPrefixChildren+1 {
+		t.Fatalf("session: has %d children, want %d", len(children), maxPrefixChildren+1)
+	}
+	other := root[0].other
+	if other == nil || other.Prefix != "session:"+otherPattern || other.Keys != 500 || other.Memory != 500*100 || len(other.Children()) != 0 {
+		t.Fatalf("folded children are %+v, want session:%s of 500 keys", other, otherPattern)
+	}
+	if first := root[0].children["session:0:"]; first == nil || first.Keys != 2 || first.Expiring != 1 {
+		t.Errorf("session:0: is %+v, want 2 keys, 1 expiring", first)
+	}
+	if top := trie.Top(2); len(top) != 2 || top[1] != other {
+		t.Errorf("top prefixes are %+v, want session: and the folded children", top)
+	}
+}
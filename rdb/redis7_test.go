@@ -0,0 +1,343 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// redis7Dump builds a dump byte by byte, as redis 7.x saves it (rdb.c,
+// listpack.c and t_stream.c), independently of the Encoder.
+type redis7Dump struct {
+	bytes.Buffer
+	sizes map[string]uint64 // Bytes of the value of every key
+}
+
+func (d *redis7Dump) raw(b ...byte) *redis7Dump {
+	d.Write(b)
+	return d
+}
+
+// rdbSaveLen of a length up to 14 bits.
+func (d *redis7Dump) len(n int) *redis7Dump {
+	if n < 1<<6 {
+		return d.raw(byte(n))
+	}
+	return d.raw(byte(n>>8)|Type14Bit<<6, byte(n))
+}
+
+// rdbSaveRawString without integer encoding nor compression.
+func (d *redis7Dump) str(s string) *redis7Dump {
+	d.len(len(s))
+	d.WriteString(s)
+	return d
+}
+
+func (d *redis7Dump) id128(ms, seq uint64) *redis7Dump {
+	binary.Write(d, binary.BigEndian, ms)
+	binary.Write(d, binary.BigEndian, seq)
+	return d
+}
+
+// rdbSaveMillisecondTime
+func (d *redis7Dump) ms(t uint64) *redis7Dump {
+	binary.Write(d, binary.LittleEndian, t)
+	return d
+}
+
+// key writes the type and the key, value writes its value.
+func (d *redis7Dump) key(t byte, key string, value func(d *redis7Dump)) *redis7Dump {
+	d.raw(t).str(key)
+	start := d.Len()
+	value(d)
+	d.sizes[key] = uint64(d.Len() - start)
+	return d
+}
+
+// end writes the EOF opcode and the crc64 of the dump.
+func (d *redis7Dump) end() []byte {
+	d.raw(FlagOpcodeEOF)
+	binary.Write(d, binary.LittleEndian, crc64Update(0, d.Bytes()))
+	return d.Bytes()
+}
+
+// listpack of the encoded entries: total bytes and elements, the entries and the terminator.
+func listpack(elements int, entries ...[]byte) string {
+	var lp bytes.Buffer
+	size := 4 + 2 + 1
+	for _, entry := range entries {
+		size += len(entry)
+	}
+	binary.Write(&lp, binary.LittleEndian, uint32(size))
+	binary.Write(&lp, binary.LittleEndian, uint16(elements))
+	for _, entry := range entries {
+		lp.Write(entry)
+	}
+	lp.WriteByte(ListPackEnd)
+	return lp.String()
+}
+
+// Listpack string of 6 bits: 10xxxxxx, the string and the backlen.
+func lpStr(s string) []byte {
+	return append(append([]byte{0x80 | byte(len(s))}, s...), byte(1+len(s)))
+}
+
+// Listpack integer of 7 bits: 0xxxxxxx and the backlen.
+func lpUint7(v byte) []byte {
+	return []byte{v, 1}
+}
+
+const redis7Function = "#!lua name=mylib\nredis.register_function('myfunc', function(keys, args) return 1 end)"
+
+var redis7Long = strings.Repeat("x", 70)
+
+// stream writes a stream of 3 entries, the second one deleted, and a group
+// with an entry pending, the consumer has an active time since STREAM_LISTPACKS_3.
+func stream(t byte, activeTime uint64) func(d *redis7Dump) {
+	return func(d *redis7Dump) {
+		d.len(1)
+		d.len(16).id128(1, 0) // Master id of the node
+		d.str(listpack(24,
+			// Master entry: count, deleted, fields and the terminator.
+			lpUint7(2), lpUint7(1), lpUint7(1), lpStr("f"), lpUint7(0),
+			// 1-0 with the master fields.
+			lpUint7(StreamItemFlagSameFields), lpUint7(0), lpUint7(0), lpStr("v1"), lpUint7(4),
+			// 1-1, deleted.
+			lpUint7(StreamItemFlagSameFields|StreamItemFlagDeleted), lpUint7(0), lpUint7(1), lpStr("v2"), lpUint7(4),
+			// 2-0 with its own fields.
+			lpUint7(StreamItemFlagNone), lpUint7(1), lpUint7(0), lpUint7(2), lpStr("b"), lpStr("y"), lpStr("a"), lpStr("x"), lpUint7(8),
+		))
+		d.len(2)        // Length
+		d.len(2).len(0) // Last id
+		d.len(1).len(0) // First id
+		d.len(1).len(1) // Max deleted id
+		d.len(3)        // Entries added
+		d.len(1).str("g").len(2).len(0).len(3)
+		d.len(1).id128(2, 0).ms(1700000000000).len(1)
+		d.len(1).str("c").ms(1700000000500)
+		if t >= TypeStreamListPacks3 {
+			d.ms(activeTime)
+		}
+		d.len(1).id128(2, 0)
+	}
+}
+
+// buildRedis7Dump writes a key of every type of redis 7.x, upgraded writes
+// stream2 as STREAM_LISTPACKS_3 with the active time redis 7.2 gives to its
+// consumer when it loads it, its seen time.
+func buildRedis7Dump(upgraded bool) *redis7Dump {
+	d := &redis7Dump{sizes: make(map[string]uint64)}
+	d.WriteString("REDIS0012")
+	d.raw(FlagOpcodeAux).str("redis-ver").str("7.4.0")
+	d.raw(FlagOpcodeAux).str("redis-bits").raw(TypeEncVal<<6|EncodeInt8, 64)
+	d.raw(FlagOpcodeFunction2).str(redis7Function)
+	d.raw(FlagOpcodeSelectDB).len(0)
+	d.raw(FlagOpcodeResizeDB).len(6).len(0)
+	// Slot 866 holding the keys, none with an expire.
+	d.raw(FlagOpcodeSlotInfo).len(866).len(6).len(0)
+
+	d.key(TypeHashListPack, "hash", func(d *redis7Dump) {
+		d.str(listpack(8,
+			lpStr("f1"), lpStr("v1"),
+			lpStr("f2"), lpUint7(2),
+			lpStr("f3"), []byte{0xc3, 0xe8, 2}, // 13 bits integer 1000
+			lpStr("f4"), append(append([]byte{0xe0, 70}, redis7Long...), 72), // 12 bits string
+		))
+	})
+	d.key(TypeZsetListPack, "zset", func(d *redis7Dump) {
+		d.str(listpack(8,
+			lpStr("m3"), []byte{0xdf, 0xfe, 2}, // 13 bits integer -2
+			lpStr("m1"), lpUint7(1),
+			lpStr("m2"), lpStr("2.5"),
+			lpStr("m4"), []byte{0xf1, 0x10, 0x27, 3}, // 16 bits integer 10000
+		))
+	})
+	d.key(TypeSetListPack, "set", func(d *redis7Dump) {
+		d.str(listpack(3, lpStr("a"), lpStr("b"), lpUint7(7)))
+	})
+	d.key(TypeListQuickList2, "list", func(d *redis7Dump) {
+		d.len(3)
+		d.len(QuickListNodePacked).str(listpack(3, lpStr("a"), lpStr("b"), lpUint7(1)))
+		d.len(QuickListNodePlain).str("plain element")
+		d.len(QuickListNodePacked).str(listpack(1, lpStr("c")))
+	})
+	if upgraded {
+		d.key(TypeStreamListPacks3, "stream2", stream(TypeStreamListPacks3, 1700000000500))
+	} else {
+		d.key(TypeStreamListPacks2, "stream2", stream(TypeStreamListPacks2, 0))
+	}
+	d.key(TypeStreamListPacks3, "stream", stream(TypeStreamListPacks3, 1700000000000))
+	return d
+}
+
+// redis7Recorder keeps the values of the dump by key.
+type redis7Recorder struct {
+	NopHandler
+	aux       map[string]string
+	functions []FunctionLibrary
+	values    map[string]KeyRecord
+}
+
+func (r *redis7Recorder) OnAux(aux AuxField) error {
+	r.aux[aux.Key()] = aux.Value()
+	return nil
+}
+
+func (r *redis7Recorder) OnFunction(f FunctionLibrary) error {
+	r.functions = append(r.functions, f)
+	return nil
+}
+
+func (r *redis7Recorder) OnList(l ListObject) error     { r.values[l.Key()] = l; return nil }
+func (r *redis7Recorder) OnSet(s Set) error             { r.values[s.Key()] = s; return nil }
+func (r *redis7Recorder) OnZSet(zs SortedSet) error     { r.values[zs.Key()] = zs; return nil }
+func (r *redis7Recorder) OnHash(hm HashMap) error       { r.values[hm.Key()] = hm; return nil }
+func (r *redis7Recorder) OnStream(rs RedisStream) error { r.values[rs.Key()] = rs; return nil }
+
+func TestRedis7Dump(t *testing.T) {
+	r := &redis7Recorder{aux: make(map[string]string), values: make(map[string]KeyRecord)}
+	decoder := NewDecoder(bytes.NewReader(buildRedis7Dump(false).end()), r)
+	if err := decoder.Decode(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoder.Version() != 12 || decoder.Checksum() == 0 {
+		t.Errorf("version %d, checksum %x", decoder.Version(), decoder.Checksum())
+	}
+	if r.aux["redis-ver"] != "7.4.0" || r.aux["redis-bits"] != "64" {
+		t.Errorf("aux fields are %v", r.aux)
+	}
+	if len(r.functions) != 1 || r.functions[0].Engine != "lua" || r.functions[0].Name != "mylib" || r.functions[0].Code != redis7Function {
+		t.Errorf("functions are %+v", r.functions)
+	}
+
+	hash, _ := r.values["hash"].(HashMap)
+	wantHash := []HashEntry{{"f1", "v1"}, {"f2", "2"}, {"f3", "1000"}, {"f4", redis7Long}}
+	if !reflect.DeepEqual(hash.Entry, wantHash) || hash.Encoding() != "listpack" {
+		t.Errorf("hash is %s %+v, want listpack %+v", hash.Encoding(), hash.Entry, wantHash)
+	}
+
+	zset, _ := r.values["zset"].(SortedSet)
+	wantZset := map[string]float64{"m3": -2, "m1": 1, "m2": 2.5, "m4": 10000}
+	if len(zset.Entries) != len(wantZset) || zset.Encoding() != "listpack" {
+		t.Errorf("zset is %s %+v, want listpack %v", zset.Encoding(), zset.Entries, wantZset)
+	}
+	for _, entry := range zset.Entries {
+		if score, ok := wantZset[ToString(entry.Field)]; !ok || score != entry.Score {
+			t.Errorf("zset member %v has the score %v, want %v", entry.Field, entry.Score, score)
+		}
+	}
+
+	set, _ := r.values["set"].(Set)
+	if !reflect.DeepEqual(set.Entries, []string{"a", "b", "7"}) || set.Encoding() != "listpack" {
+		t.Errorf("set is %s %q", set.Encoding(), set.Entries)
+	}
+
+	list, _ := r.values["list"].(ListObject)
+	if !reflect.DeepEqual(list.Entries, []string{"a", "b", "1", "plain element", "c"}) || list.Encoding() != "quicklist" || list.Field.nodes != 3 {
+		t.Errorf("list is %s of %d nodes %q", list.Encoding(), list.Field.nodes, list.Entries)
+	}
+
+	wantMessages := []StreamMessage{
+		{Id: StreamId{1, 0}, Fields: StreamFields{{"f", "v1"}}},
+		{Id: StreamId{1, 1}, Deleted: true, Fields: StreamFields{{"f", "v2"}}},
+		{Id: StreamId{2, 0}, Fields: StreamFields{{"b", "y"}, {"a", "x"}}},
+	}
+	wantPending := []StreamPending{{Id: StreamId{2, 0}, Consumer: "c", DeliveryTime: 1700000000000, DeliveryCount: 1}}
+	// The active time of the consumers is saved since STREAM_LISTPACKS_3.
+	for key, activeTime := range map[string]uint64{"stream2": 0, "stream": 1700000000000} {
+		rs, _ := r.values[key].(RedisStream)
+		if messages := rs.Messages(); !reflect.DeepEqual(messages, wantMessages) {
+			t.Errorf("%s messages are %+v, want %+v", key, messages, wantMessages)
+		}
+		if rs.Length != 2 || rs.LastId != (StreamId{2, 0}) || rs.FirstId != (StreamId{1, 0}) || rs.MaxDeletedId != (StreamId{1, 1}) || rs.EntriesAdded != 3 {
+			t.Errorf("%s is %+v", key, rs)
+		}
+		if len(rs.Groups) != 1 {
+			t.Fatalf("%s groups are %+v", key, rs.Groups)
+		}
+		group := rs.Groups[0]
+		if group.Name != "g" || group.LastId != "2-0" || group.EntriesRead != 3 || !reflect.DeepEqual(group.Pending(), wantPending) {
+			t.Errorf("%s group is %+v, pending %+v", key, group, group.Pending())
+		}
+		if len(group.Consumers) != 1 || group.Consumers[0].Name != "c" || group.Consumers[0].SeenTime != 1700000000500 || group.Consumers[0].ActiveTime != activeTime {
+			t.Errorf("%s consumers are %+v", key, group.Consumers)
+		}
+	}
+}
+
+// keyEntries keeps the keys of a KeysOnly decoding.
+type keyEntries struct {
+	NopHandler
+	keys map[string]KeyEntry
+}
+
+func (k *keyEntries) OnKey(key KeyEntry) error {
+	k.keys[key.Key()] = key
+	return nil
+}
+
+// The values are skipped with their size when only the keys are decoded.
+func TestRedis7DumpKeysOnly(t *testing.T) {
+	dump := buildRedis7Dump(false)
+	k := &keyEntries{keys: make(map[string]KeyEntry)}
+	decoder := NewDecoder(bytes.NewReader(dump.end()), k)
+	decoder.KeysOnly = true
+	if err := decoder.Decode(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	types := map[string]byte{"hash": TypeHashListPack, "zset": TypeZsetListPack, "set": TypeSetListPack, "list": TypeListQuickList2, "stream2": TypeStreamListPacks2, "stream": TypeStreamListPacks3}
+	if len(k.keys) != len(types) {
+		t.Fatalf("keys are %+v", k.keys)
+	}
+	for key, t2 := range types {
+		if entry := k.keys[key]; entry.RDBType != t2 || entry.Size != dump.sizes[key] {
+			t.Errorf("%s is of type %d, %d bytes, want %d, %d bytes", key, entry.RDBType, entry.Size, t2, dump.sizes[key])
+		}
+	}
+}
+
+// The dump checks out with -verify, a changed byte does not.
+func TestRedis7DumpVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redis7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dump := buildRedis7Dump(false).end()
+	file := filepath.Join(dir, "redis7.rdb")
+	verify := func() error {
+		if err := ioutil.WriteFile(file, dump, 0644); err != nil {
+			t.Fatal(err)
+		}
+		verifier, err := NewVerifier(file, protocol.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return verifier.Parse()
+	}
+	if err := verify(); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	i := bytes.Index(dump, []byte("plain element"))
+	dump[i] = 'P'
+	var checksumErr *ChecksumError
+	if err := verify(); !errors.As(err, &checksumErr) {
+		t.Errorf("verify of a changed dump: %v, want a checksum mismatch", err)
+	}
+}
+
+// The Encoder writes the same keys back in version 12, stream2 upgraded.
+func TestRedis7DumpRoundTrip(t *testing.T) {
+	want, _ := decodeKeys(t, buildRedis7Dump(true).end())
+	got, _ := decodeKeys(t, encodeKeys(t, buildRedis7Dump(false).end(), 12))
+	compareKeys(t, "redis 7 dump", got, want)
+}
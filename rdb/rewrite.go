@@ -0,0 +1,110 @@
+package rdb
+
+import (
+	"fmt"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"github.com/fatih/color"
+	"os"
+)
+
+// RewriteRdb writes the keys matching the filter into a new dump of the same
+// version. The keys are copied as they are serialized, with their encodings
+// (ziplist, intset, listpack...), the other keys are skipped undecoded.
+type RewriteRdb struct {
+	NopHandler // The keys are reported by OnRawKey
+
+	handler          *os.File
+	output           *os.File
+	skipZeroChecksum bool
+	filter           *KeyFilter
+	decoder          *Decoder
+	encoder          *Encoder
+	db               *SelectionDB // Database selected by the dump, written before its first key
+	kept             uint64
+	dropped          uint64
+}
+
+// NewRewriter rewrites the file into opts.Output, which is the path of the new dump.
+func NewRewriter(file string, opts protocol.Options) (protocol.Parser, error) {
+	handler, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := NewKeyFilter(opts)
+	if err != nil {
+		handler.Close()
+		return nil, err
+	}
+	output, err := os.Create(opts.Output)
+	if err != nil {
+		handler.Close()
+		return nil, err
+	}
+	return &RewriteRdb{handler: handler, output: output, skipZeroChecksum: opts.SkipZeroChecksum, filter: filter}, nil
+}
+
+func (r *RewriteRdb) Parse() error {
+	defer r.handler.Close()
+	defer r.output.Close()
+	r.decoder = NewDecoder(r.handler, r)
+	r.decoder.SkipZeroChecksum = r.skipZeroChecksum
+	r.decoder.Filter = func(key KeyInfo) bool {
+		if r.filter == nil || r.filter.Match(key) {
+			return true
+		}
+		r.dropped++
+		return false
+	}
+	if err := r.decoder.Decode(); err != nil {
+		// A dump without its end is of no use, redis would refuse it.
+		os.Remove(r.output.Name())
+		return err
+	}
+	fmt.Println(color.GreenString("OK"), fmt.Sprintf("%d keys written into %s, %d keys dropped", r.kept, r.output.Name(), r.dropped))
+	return nil
+}
+
+// The encoder writes the version of the dump, known once its header is read.
+func (r *RewriteRdb) encode() *Encoder {
+	if r.encoder == nil {
+		r.encoder, _ = NewEncoder(r.output, r.decoder.Version())
+	}
+	return r.encoder
+}
+
+func (r *RewriteRdb) OnAux(aux AuxField) error {
+	return r.encode().OnAux(aux)
+}
+
+func (r *RewriteRdb) OnSelectDB(db SelectionDB) error {
+	r.db = &db
+	return nil
+}
+
+// The sizes of the original databases would make redis allocate tables for keys which are not there anymore.
+func (r *RewriteRdb) OnResizeDB(resize ResizeDB) error {
+	return nil
+}
+
+func (r *RewriteRdb) OnRawKey(key KeyInfo, record []byte) error {
+	if r.db != nil {
+		if err := r.encode().OnSelectDB(*r.db); err != nil {
+			return err
+		}
+		r.db = nil
+	}
+	r.kept++
+	return r.encode().WriteRecord(record)
+}
+
+func (r *RewriteRdb) OnFunction(f FunctionLibrary) error {
+	return r.encode().OnFunction(f)
+}
+
+func (r *RewriteRdb) OnModuleAux(aux ModuleAux) error {
+	return r.encode().OnModuleAux(aux)
+}
+
+func (r *RewriteRdb) OnEnd() error {
+	return r.encode().OnEnd()
+}
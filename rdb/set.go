@@ -15,29 +15,28 @@ type Set struct {
 	Entries []string
 }
 
-func (r *ParseRdb) readSet(key KeyObject) error {
-	length, _, err := r.loadLen()
+func (d *Decoder) readSet(key KeyObject) error {
+	length, _, err := d.loadLen()
 	if err != nil {
 		return err
 	}
-	set := Set{Field: key, Len: length, Entries: make([]string, 0, length)}
+	set := Set{Field: key, Len: length, Entries: make([]string, 0, allocCap(length))}
 	for i := uint64(0); i < length; i++ {
-		member, err := r.loadString()
+		member, err := d.loadString()
 		if err != nil {
 			return err
 		}
 		set.Entries = append(set.Entries, ToString(member))
 	}
-	r.d2 <- set
-
-	return nil
+	return d.handler.OnSet(set)
 }
 
-func (r *ParseRdb) readIntSet(key KeyObject) error {
-	b, err := r.loadString()
+func (d *Decoder) readIntSet(key KeyObject) error {
+	b, err := d.loadString()
 	if err != nil {
 		return err
 	}
+	key.pack(b)
 	buf := newInput(b)
 	sizeBytes, err := buf.Slice(4)
 	if err != nil {
@@ -53,7 +52,7 @@ func (r *ParseRdb) readIntSet(key KeyObject) error {
 	}
 	cardinality := binary.LittleEndian.Uint32(lenBytes)
 	//intSetItem := make([][]byte, 0, cardinality)
-	set := Set{Field: key, Len: uint64(cardinality), Entries: make([]string, 0, cardinality)}
+	set := Set{Field: key, Len: uint64(cardinality), Entries: make([]string, 0, allocCap(uint64(cardinality)))}
 	for i := uint32(0); i < cardinality; i++ {
 		intBytes, err := buf.Slice(int(intSize))
 		if err != nil {
@@ -70,8 +69,25 @@ func (r *ParseRdb) readIntSet(key KeyObject) error {
 		}
 		set.Entries = append(set.Entries, ToString(intString))
 	}
-	r.d2 <- set
-	return nil
+	return d.handler.OnSet(set)
+}
+
+func (d *Decoder) readSetListPack(key KeyObject) error {
+	b, err := d.loadString()
+	if err != nil {
+		return err
+	}
+	key.pack(b)
+	items, err := loadListPack(b)
+	if err != nil {
+		return err
+	}
+
+	set := Set{Field: key, Len: uint64(len(items)), Entries: make([]string, 0, len(items))}
+	for _, v := range items {
+		set.Entries = append(set.Entries, ToString(v))
+	}
+	return d.handler.OnSet(set)
 }
 
 func (s Set) Type() string {
@@ -79,11 +95,19 @@ func (s Set) Type() string {
 }
 
 func (s Set) String() string {
-	return fmt.Sprintf("{Set: {Key: %s, Len: %d, Item: %s}}", s.Key(), s.Len, s.Value())
+	return fmt.Sprintf("{Set: {Key: %s, Len: %d, Item: %s}}", ToString(s.Field), s.Len, s.Value())
 }
 
 func (s Set) Key() string {
-	return ToString(s.Field)
+	return s.Field.Value()
+}
+
+func (s Set) Meta() KeyObject {
+	return s.Field
+}
+
+func (s Set) Encoding() string {
+	return s.Field.Encoding()
 }
 
 func (s Set) Value() string {
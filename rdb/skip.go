@@ -0,0 +1,259 @@
+package rdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Digits and sign of the longest 64 bits integer.
+const maxIntLength = 20
+
+// skipObject consumes the value of the type without decoding it, strings are
+// neither allocated nor decompressed, only their length is read.
+func (d *Decoder) skipObject(t byte) error {
+	switch t {
+	case TypeString, TypeHashZipMap, TypeListZipList, TypeSetIntSet, TypeZsetZipList, TypeHashZipList,
+		TypeHashListPack, TypeZsetListPack, TypeSetListPack:
+		// A single string, the ziplist, intset or listpack blob.
+		return d.skipString()
+	case TypeList, TypeSet, TypeListQuickList:
+		return d.skipStrings(1)
+	case TypeHash:
+		return d.skipStrings(2)
+	case TypeZset:
+		length, _, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < length; i++ {
+			if err := d.skipString(); err != nil {
+				return err
+			}
+			if err := d.skipFloat(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TypeZset2:
+		length, _, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < length; i++ {
+			if err := d.skipString(); err != nil {
+				return err
+			}
+			if err := d.input.Discard(8); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TypeListQuickList2:
+		length, _, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < length; i++ {
+			// Container of the node, then the node itself.
+			if _, _, err := d.loadLen(); err != nil {
+				return err
+			}
+			if err := d.skipString(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TypeStreamListPacks, TypeStreamListPacks2, TypeStreamListPacks3:
+		return d.skipStream(t)
+	case TypeModule, TypeModule2:
+		return d.skipModule(t)
+	}
+	return errors.New(fmt.Sprintf("unknown object type %d", t))
+}
+
+func (d *Decoder) skipString() error {
+	length, encoded, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	_, err = d.discardString(length, encoded)
+	return err
+}
+
+// skipStringEncoding skips a string and returns its encoding once loaded: int
+// for the integers, embstr or raw by their length for the others.
+func (d *Decoder) skipStringEncoding() (string, error) {
+	length, encoded, err := d.loadLen()
+	if err != nil {
+		return "", err
+	}
+	if !encoded && length <= maxIntLength {
+		// Integers out of the 32 bits range are saved as strings, redis encodes them when loading.
+		val := make([]byte, length)
+		if _, err := io.ReadFull(d.input, val); err != nil {
+			return "", err
+		}
+		return stringEncoding(val), nil
+	}
+	if encoded && length != EncodeLZF {
+		_, err := d.discardString(length, encoded)
+		return "int", err
+	}
+	length, err = d.discardString(length, encoded)
+	return lengthEncoding(length), err
+}
+
+// discardString consumes the string of the length read by loadLen, its length uncompressed is returned.
+func (d *Decoder) discardString(length uint64, encoded bool) (uint64, error) {
+	if !encoded {
+		return length, d.input.Discard(length)
+	}
+	switch length {
+	case EncodeInt8:
+		return 1, d.input.Discard(1)
+	case EncodeInt16:
+		return 2, d.input.Discard(2)
+	case EncodeInt32:
+		return 4, d.input.Discard(4)
+	case EncodeLZF:
+		compressed, _, err := d.loadLen()
+		if err != nil {
+			return 0, err
+		}
+		uncompressed, _, err := d.loadLen()
+		if err != nil {
+			return 0, err
+		}
+		return uncompressed, d.input.Discard(compressed)
+	}
+	return 0, errors.New("Unknown string encode type ")
+}
+
+// A length followed by that many elements of n strings.
+func (d *Decoder) skipStrings(n int) error {
+	length, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < length; i++ {
+		for j := 0; j < n; j++ {
+			if err := d.skipString(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) skipLens(n int) error {
+	for i := 0; i < n; i++ {
+		if _, _, err := d.loadLen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scores of the old sorted sets, a length byte and the string, nan and infinities have no string.
+func (d *Decoder) skipFloat() error {
+	b, err := d.input.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b >= 0xfd {
+		return nil
+	}
+	return d.input.Discard(uint64(b))
+}
+
+// The layout of loadStreamListPack: listpack nodes, metadata, then the groups
+// with their PEL and consumers.
+func (d *Decoder) skipStream(t byte) error {
+	// Master id and listpack of every node.
+	if err := d.skipStrings(2); err != nil {
+		return err
+	}
+	// Length and last id, first id, max deleted id and entries added since v2.
+	metadata := 3
+	if t >= TypeStreamListPacks2 {
+		metadata += 5
+	}
+	if err := d.skipLens(metadata); err != nil {
+		return err
+	}
+	groups, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if err := d.skipString(); err != nil {
+			return err
+		}
+		// Last id, entries read since v2.
+		lens := 2
+		if t >= TypeStreamListPacks2 {
+			lens++
+		}
+		if err := d.skipLens(lens); err != nil {
+			return err
+		}
+		pel, _, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pel; j++ {
+			// Raw id and delivery time, then the delivery count.
+			if err := d.input.Discard(16 + 8); err != nil {
+				return err
+			}
+			if _, _, err := d.loadLen(); err != nil {
+				return err
+			}
+		}
+		consumers, _, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if err := d.skipString(); err != nil {
+				return err
+			}
+			// Seen time, active time since v3.
+			times := uint64(8)
+			if t >= TypeStreamListPacks3 {
+				times += 8
+			}
+			if err := d.input.Discard(times); err != nil {
+				return err
+			}
+			pel, _, err := d.loadLen()
+			if err != nil {
+				return err
+			}
+			if err := d.input.Discard(pel * 16); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Values with opcodes are walked through, the others need the registered decoder to be read.
+func (d *Decoder) skipModule(t byte) error {
+	id, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	reader := &ModuleReader{d: d, framed: t == TypeModule2}
+	if reader.framed {
+		return reader.skip()
+	}
+	name, encver := ModuleTypeName(id)
+	decoder := lookupModule(id)
+	if decoder == nil {
+		return errors.New("module " + name + " saved without opcodes needs a registered decoder")
+	}
+	_, err = decoder(reader, encver)
+	return err
+}
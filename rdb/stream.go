@@ -1,13 +1,17 @@
 package rdb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/8090Lambert/go-redis-parser/protocol"
 	"io"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 type RedisStream struct {
@@ -16,6 +20,11 @@ type RedisStream struct {
 	Length  uint64                 `json:"length"`
 	LastId  StreamId               `json:"lastId"`
 	Groups  []StreamGroup          `json:"groups"`
+	// Since redis 7.0 (RDB_TYPE_STREAM_LISTPACKS_2)
+	FirstId      StreamId `json:"firstId"`
+	MaxDeletedId StreamId `json:"maxDeletedId"`
+	EntriesAdded uint64   `json:"entriesAdded"`
+	rdbType      byte     // RDB type of the stream in the dump
 }
 
 type StreamEntries struct {
@@ -31,12 +40,14 @@ type StreamId struct {
 type StreamGroup struct {
 	Name             string                 `json:"groupName"`
 	LastId           string                 `json:"lastId"`
+	EntriesRead      uint64                 `json:"entriesRead,omitempty"` // Since redis 7.0
 	PendingEntryList map[string]interface{} `json:"pending"`
 	Consumers        []StreamConsumer       `json:"consumers"`
 }
 
 type StreamConsumer struct {
 	SeenTime         uint64                 `json:"seenTime"`
+	ActiveTime       uint64                 `json:"activeTime,omitempty"` // Since redis 7.2
 	Name             string                 `json:"consumerName"`
 	PendingEntryList map[string]interface{} `json:"pending"`
 }
@@ -47,42 +58,56 @@ type StreamNACK struct {
 	DeliveryCount uint64         `json:"deliveryCount"`
 }
 
-func (r *ParseRdb) loadStreamListPack(key KeyObject) error {
+func (d *Decoder) loadStreamListPack(key KeyObject, t byte) error {
 	// Stream entry
-	entries, err := r.loadStreamEntry()
+	entries, err := d.loadStreamEntry(&key)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	length, _, _ := r.loadLen()
-	ms, _, _ := r.loadLen()
-	seq, _, _ := r.loadLen()
-	lastId := StreamId{Ms: ms, Sequence: seq}
-	stream := RedisStream{Field: key, LastId: lastId, Length: length, Entries: nil, Groups: nil}
+	length, _, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	lastId, err := d.loadStreamId()
+	if err != nil {
+		return err
+	}
+	stream := RedisStream{Field: key, LastId: lastId, Length: length, Entries: nil, Groups: nil, rdbType: t}
 	if len(entries) > 0 {
 		stream.Entries = entries
 	}
+	if t >= TypeStreamListPacks2 {
+		if stream.FirstId, err = d.loadStreamId(); err != nil {
+			return err
+		}
+		if stream.MaxDeletedId, err = d.loadStreamId(); err != nil {
+			return err
+		}
+		if stream.EntriesAdded, _, err = d.loadLen(); err != nil {
+			return err
+		}
+	}
 	//Stream group
-	groups, err := r.loadStreamGroup()
+	groups, err := d.loadStreamGroup(t)
+	if err != nil {
+		return err
+	}
 	if len(groups) > 0 {
 		stream.Groups = groups
 	}
-	//r.d1 = append(r.d1, stream.String())
-	r.d1 = append(r.d1, stream)
-	r.d2 <- stream
-
-	return nil
+	return d.handler.OnStream(stream)
 }
 
-func (r *ParseRdb) loadStreamEntry() (map[string]interface{}, error) {
-	entryLength, _, err := r.loadLen()
+func (d *Decoder) loadStreamEntry(key *KeyObject) (map[string]interface{}, error) {
+	entryLength, _, err := d.loadLen()
 	if err != nil {
 		return nil, err
 	}
 
-	entries := make(map[string]interface{}, entryLength)
+	entries := make(map[string]interface{}, allocCap(entryLength))
 	for i := uint64(0); i < entryLength; i++ {
-		streamAuxBytes, err := r.loadString()
+		streamAuxBytes, err := d.loadString()
 		if err != nil {
 			return nil, err
 		}
@@ -98,7 +123,11 @@ func (r *ParseRdb) loadStreamEntry() (map[string]interface{}, error) {
 		streamId := StreamId{Ms: binary.BigEndian.Uint64(msBytes), Sequence: binary.BigEndian.Uint64(seqBytes)}
 		messageId := streamId.String()
 
-		headerBytes, err := r.loadString()
+		headerBytes, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		key.pack(headerBytes)
 		lp := newInput(headerBytes)
 		// Skip the header.
 		// 4b total-bytes + 2b num-elements
@@ -114,71 +143,94 @@ func (r *ParseRdb) loadStreamEntry() (map[string]interface{}, error) {
 	return entries, nil
 }
 
-func (r *ParseRdb) loadStreamGroup() ([]StreamGroup, error) {
+func (d *Decoder) loadStreamGroup(t byte) ([]StreamGroup, error) {
 	/*Redis group, struct is this
 	typedef struct streamCG {
 	 	streamID last_id
 		rax *pel
 		rax *consumers;
 	}*/
-	groupCount, _, err := r.loadLen()
+	groupCount, _, err := d.loadLen()
 	if err != nil {
 		return nil, err
 	}
 
-	groups := make([]StreamGroup, 0, groupCount)
+	groups := make([]StreamGroup, 0, allocCap(groupCount))
 	for i := uint64(0); i < groupCount; i++ {
-		gName, err := r.loadString()
+		gName, err := d.loadString()
 		if err != nil {
 			return nil, err
 		}
-		ms, _, _ := r.loadLen()
-		seq, _, _ := r.loadLen()
 		// GroupLastId
-		lastId := StreamId{Ms: ms, Sequence: seq}
+		lastId, err := d.loadStreamId()
+		if err != nil {
+			return nil, err
+		}
 		group := StreamGroup{Name: string(gName), LastId: lastId.String()}
+		if t >= TypeStreamListPacks2 {
+			if group.EntriesRead, _, err = d.loadLen(); err != nil {
+				return nil, err
+			}
+		}
 
 		// Global PendingEntryList
-		pel, _, _ := r.loadLen()
-		groupPendingEntries := make(map[string]interface{}, pel)
+		pel, _, err := d.loadLen()
+		if err != nil {
+			return nil, err
+		}
+		groupPendingEntries := make(map[string]interface{}, allocCap(pel))
 		for i := uint64(0); i < pel; i++ {
-			io.ReadFull(r.handler, buff)
-			msBytes := buff
-			rawIdObj := StreamId{Ms: binary.BigEndian.Uint64(msBytes)}
-			io.ReadFull(r.handler, buff)
-			seqBytes := buff
-			rawIdObj.Sequence = binary.BigEndian.Uint64(seqBytes)
+			rawIdObj, err := d.loadRawStreamId()
+			if err != nil {
+				return nil, err
+			}
 			rawId := rawIdObj.String()
 
-			io.ReadFull(r.handler, buff)
-			deliveryTime := uint64(binary.LittleEndian.Uint64(buff))
-			deliveryCount, _, _ := r.loadLen()
+			deliveryTime, err := d.loadMillisecondTime()
+			if err != nil {
+				return nil, err
+			}
+			deliveryCount, _, err := d.loadLen()
+			if err != nil {
+				return nil, err
+			}
 			// This pending message not acknowledged, it will in consumer group
 			groupPendingEntries[rawId] = StreamNACK{DeliveryTime: deliveryTime, DeliveryCount: deliveryCount}
 		}
 
 		// Consumer
-		consumerCount, _, _ := r.loadLen()
-		consumers := make([]StreamConsumer, 0, consumerCount)
+		consumerCount, _, err := d.loadLen()
+		if err != nil {
+			return nil, err
+		}
+		consumers := make([]StreamConsumer, 0, allocCap(consumerCount))
 		for i := uint64(0); i < consumerCount; i++ {
-			cName, err := r.loadString()
+			cName, err := d.loadString()
+			if err != nil {
+				return nil, err
+			}
+			seenTime, err := d.loadMillisecondTime()
 			if err != nil {
 				return nil, err
 			}
-			io.ReadFull(r.handler, buff)
-			seenTime := uint64(binary.LittleEndian.Uint64(buff))
 			consumer := StreamConsumer{Name: string(cName), SeenTime: seenTime}
+			if t >= TypeStreamListPacks3 {
+				if consumer.ActiveTime, err = d.loadMillisecondTime(); err != nil {
+					return nil, err
+				}
+			}
 
 			// Consumer PendingEntryList
-			pel, _, _ := r.loadLen()
-			consumersPendingEntries := make(map[string]interface{}, pel)
+			pel, _, err := d.loadLen()
+			if err != nil {
+				return nil, err
+			}
+			consumersPendingEntries := make(map[string]interface{}, allocCap(pel))
 			for i := uint64(0); i < pel; i++ {
-				io.ReadFull(r.handler, buff)
-				msBytes := buff
-				rawIdObj := StreamId{Ms: binary.BigEndian.Uint64(msBytes)}
-				io.ReadFull(r.handler, buff)
-				seqBytes := buff
-				rawIdObj.Sequence = binary.BigEndian.Uint64(seqBytes)
+				rawIdObj, err := d.loadRawStreamId()
+				if err != nil {
+					return nil, err
+				}
 				rawId := rawIdObj.String()
 
 				// NoAck pending message
@@ -206,192 +258,131 @@ func (r *ParseRdb) loadStreamGroup() ([]StreamGroup, error) {
 	return groups, nil
 }
 
+// Stream id encoded as two lengths, ms and sequence.
+func (d *Decoder) loadStreamId() (StreamId, error) {
+	ms, _, err := d.loadLen()
+	if err != nil {
+		return StreamId{}, err
+	}
+	seq, _, err := d.loadLen()
+	if err != nil {
+		return StreamId{}, err
+	}
+	return StreamId{Ms: ms, Sequence: seq}, nil
+}
+
+// Stream id saved as 128 bits big endian, used by the pending entries.
+func (d *Decoder) loadRawStreamId() (StreamId, error) {
+	if _, err := io.ReadFull(d.input, d.buff); err != nil {
+		return StreamId{}, err
+	}
+	id := StreamId{Ms: binary.BigEndian.Uint64(d.buff)}
+	if _, err := io.ReadFull(d.input, d.buff); err != nil {
+		return StreamId{}, err
+	}
+	id.Sequence = binary.BigEndian.Uint64(d.buff)
+	return id, nil
+}
+
 func loadStreamEntryItem(lp *input, stId StreamId) (entries map[string]interface{}, err error) {
 	// Entry format:
 	// | count | deleted | num-fields | field_1 | field_2 | ... | field_N |0|
-	countBytes, err := loadStreamListPackEntry(lp)
+	countBytes, err := loadListPackEntry(lp)
 	if err != nil {
 		return nil, err
 	}
 
 	count, _ := strconv.ParseUint(string(countBytes), 10, 64)
-	deletedBytes, err := loadStreamListPackEntry(lp)
+	deletedBytes, err := loadListPackEntry(lp)
 	if err != nil {
 		return nil, err
 	}
 	deleted, _ := strconv.ParseInt(string(deletedBytes), 10, 64)
 
-	fieldsNumBytes, err := loadStreamListPackEntry(lp)
+	fieldsNumBytes, err := loadListPackEntry(lp)
 	if err != nil {
 		return nil, err
 	}
 	fieldsNum, _ := strconv.ParseUint(string(fieldsNumBytes), 10, 64)
-	fieldCollect := make([][]byte, 0, fieldsNum)
+	fieldCollect := make([][]byte, 0, allocCap(fieldsNum))
 	for i := uint64(0); i < fieldsNum; i++ {
-		tmp, err := loadStreamListPackEntry(lp)
+		tmp, err := loadListPackEntry(lp)
 		if err != nil {
 			return nil, err
 		}
 		fieldCollect = append(fieldCollect, tmp)
 	}
-	loadStreamListPackEntry(lp)
+	if _, err := loadListPackEntry(lp); err != nil {
+		return nil, err
+	}
 
 	total := uint64(count) + uint64(deleted)
-	entries = make(map[string]interface{}, total)
+	entries = make(map[string]interface{}, allocCap(total))
 	for i := uint64(0); i < total; i++ {
-		flagBytes, err := loadStreamListPackEntry(lp)
+		flagBytes, err := loadListPackEntry(lp)
 		if err != nil {
 			return nil, err
 		}
 		flag, _ := strconv.Atoi(string(flagBytes))
-		msBytes, err := loadStreamListPackEntry(lp) // ms
+		msBytes, err := loadListPackEntry(lp) // ms
 		if err != nil {
 			return nil, err
 		}
-		seqBytes, err := loadStreamListPackEntry(lp) // seq
+		seqBytes, err := loadListPackEntry(lp) // seq
 		if err != nil {
 			return nil, err
 		}
 
-		ms, _ := strconv.ParseUint(string(msBytes), 10, 64)
-		seq, _ := strconv.ParseUint(string(seqBytes), 10, 64)
-		messageId := stId.BuildOn(ms, seq).String()
+		// Deltas to the master entry are signed, the sequence may be lower than the master one.
+		ms, _ := strconv.ParseInt(string(msBytes), 10, 64)
+		seq, _ := strconv.ParseInt(string(seqBytes), 10, 64)
+		messageId := stId.BuildOn(uint64(ms), uint64(seq)).String()
 
 		hasDelete := "false"
 		if flag&StreamItemFlagDeleted != 0 {
 			hasDelete = "true"
 		}
+		// The entry has its own fields, the master ones stay for the following entries.
+		entryFieldsNum := fieldsNum
 		if flag&StreamItemFlagSameFields == 0 {
-			fieldsNumBytes, err := loadStreamListPackEntry(lp)
+			fieldsNumBytes, err := loadListPackEntry(lp)
 			if err != nil {
 				return nil, err
 			}
-			fieldsNum, _ = strconv.ParseUint(string(fieldsNumBytes), 10, 64)
+			entryFieldsNum, _ = strconv.ParseUint(string(fieldsNumBytes), 10, 64)
 		}
-		fields := make(map[string]interface{}, fieldsNum)
-		for i := uint64(0); i < fieldsNum; i++ {
-			fieldBytes := fieldCollect[i]
+		fields := make(StreamFields, 0, allocCap(entryFieldsNum))
+		for i := uint64(0); i < entryFieldsNum; i++ {
+			var fieldBytes []byte
 			if flag&StreamItemFlagSameFields == 0 {
-				fieldBytes, err = loadStreamListPackEntry(lp)
+				fieldBytes, err = loadListPackEntry(lp)
 				if err != nil {
 					return nil, err
 				}
-			} else {
+			} else if i < uint64(len(fieldCollect)) {
 				fieldBytes = fieldCollect[i]
+			} else {
+				return nil, errors.New("stream entry has more fields than the master entry")
 			}
-			vBytes, err := loadStreamListPackEntry(lp)
+			vBytes, err := loadListPackEntry(lp)
 			if err != nil {
 				return nil, err
 			}
-			fields[string(fieldBytes)] = string(vBytes)
+			fields = append(fields, StreamField{Field: string(fieldBytes), Value: string(vBytes)})
 		}
 		entries[messageId] = map[string]interface{}{"hasDeleted": hasDelete, "fields": fields}
-		loadStreamListPackEntry(lp)
+		if _, err := loadListPackEntry(lp); err != nil {
+			return nil, err
+		}
 	}
 
-	if endBytes, _ := lp.ReadByte(); endBytes != 255 {
+	if endBytes, _ := lp.ReadByte(); endBytes != ListPackEnd {
 		return nil, errors.New("ListPack expect 255 with end")
 	}
 
 	return entries, nil
 }
 
-func loadStreamListPackEntry(buf *input) ([]byte, error) {
-	special, err := buf.ReadByte()
-	if err != nil {
-		return nil, err
-	}
-
-	res := make([]byte, 0)
-	skip := 0
-	if special&0x80 == 0 {
-		skip = 1
-		res = []byte(strconv.FormatInt(int64(special&0x7F), 10))
-	} else if special&0xC0 == 0x80 {
-		length := special & 0x3F
-		skip = 1 + int(length)
-		res, err = buf.Slice(int(length))
-		if err != nil {
-			return nil, err
-		}
-	} else if special&0xE0 == 0xC0 {
-		skip = 2
-		next, err := buf.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		//int64(int32(uint32(special&0x1F)<<8|uint32(next)) << 19 >> 19)
-		res = []byte(strconv.FormatInt(int64(int32(uint32(special&0x1F)<<8|uint32(next))<<19>>19), 10))
-	} else if special&0xFF == 0xF1 {
-		skip = 3
-		b, err := buf.Slice(2)
-		if err != nil {
-			return nil, err
-		}
-		res = []byte(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10))
-	} else if special&0xFF == 0xF2 {
-		skip = 4
-		intBytes := make([]byte, 4)
-		_, err := buf.Read(intBytes[1:])
-		if err != nil {
-			return nil, err
-		}
-		res = []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(intBytes))>>8), 10))
-	} else if special&0xFF == 0xF3 {
-		skip = 5
-		intBytes, err := buf.Slice(4)
-		if err != nil {
-			return nil, err
-		}
-		res = []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(intBytes))), 10))
-	} else if special&0xFF == 0xF4 {
-		skip = 9
-		intBytes, err := buf.Slice(8)
-		if err != nil {
-			return nil, err
-		}
-		res = []byte(strconv.FormatInt(int64(binary.LittleEndian.Uint64(intBytes)), 10))
-	} else if special&0xF0 == 0xE0 {
-		b, err := buf.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		length := ((special & 0x0F) << 8) | b
-		skip = 2 + int(length)
-		res, err = buf.Slice(int(length))
-		if err != nil {
-			return nil, err
-		}
-	} else if special&0xFF == 0xf0 {
-		lenBytes, err := buf.Slice(4)
-		if err != nil {
-			return nil, err
-		}
-		length := uint64(binary.BigEndian.Uint32(lenBytes))
-		skip = 5 + int(length)
-		res, err = buf.Slice(int(length))
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		return nil, errors.New("Unknown encoding type! ")
-	}
-
-	// element-total-len
-	if skip <= 127 {
-		buf.Seek(1, 1)
-	} else if skip < 16383 {
-		buf.Seek(2, 1)
-	} else if skip < 2097151 {
-		buf.Seek(3, 1)
-	} else if skip < 268435455 {
-		buf.Seek(4, 1)
-	} else {
-		buf.Seek(5, 1)
-	}
-	return res, err
-}
-
 func (sd StreamId) String() string {
 	return strconv.FormatUint(sd.Ms, 10) + "-" + strconv.FormatUint(sd.Sequence, 10)
 }
@@ -407,15 +398,28 @@ func (rs RedisStream) Type() string {
 }
 
 func (rs RedisStream) String() string {
-	return fmt.Sprintf("{Stream: {Field: %s, Value:%s}}", rs.Key(), rs.Value())
+	return fmt.Sprintf("{Stream: {Field: %s, Value:%s}}", ToString(rs.Field), rs.Value())
 }
 
 func (rs RedisStream) Key() string {
-	return ToString(rs.Field)
+	return rs.Field.Value()
+}
+
+func (rs RedisStream) Meta() KeyObject {
+	return rs.Field
+}
+
+func (rs RedisStream) Encoding() string {
+	return rs.Field.Encoding()
 }
 
 func (rs RedisStream) Value() string {
 	format := map[string]interface{}{"LastId": rs.LastId, "Length": rs.Length}
+	if rs.EntriesAdded > 0 {
+		format["FirstId"] = rs.FirstId
+		format["MaxDeletedId"] = rs.MaxDeletedId
+		format["EntriesAdded"] = rs.EntriesAdded
+	}
 	if len(rs.Entries) > 0 {
 		format["Entries"] = rs.Entries
 	}
@@ -430,31 +434,219 @@ func (rs RedisStream) Value() string {
 	return string(output)
 }
 
+// ValueLen counts the live entries, the deleted ones stay in the listpacks until their node is trimmed.
 func (rs RedisStream) ValueLen() uint64 {
 	var length uint64
-	if len(rs.Entries) > 0 {
-		for _, item := range rs.Entries {
-			length = uint64(len(item.(map[string]interface{})))
-		}
-	}
+	rs.liveEntries(func(StreamFields) { length++ })
 	return length
 }
 
 func (rs RedisStream) ConcreteSize() uint64 {
-	if len(rs.Entries) > 0 {
-		var size uint64
-		for _, item := range rs.Entries {
-			if entry, ok := item.(map[string]interface{}); ok {
-				for _, fields := range entry {
-					collect := fields.(map[string]interface{})["fields"]
-					for key, value := range collect.(map[string]interface{}) { // entry fields and values
-						size += uint64(len([]byte(ToString(key)))) + uint64(len([]byte(ToString(value))))
-					}
+	var size uint64
+	rs.liveEntries(func(fields StreamFields) {
+		for _, field := range fields { // entry fields and values
+			size += uint64(len(field.Field)) + uint64(len(field.Value))
+		}
+	})
+	return size
+}
+
+// liveEntries calls fn with the fields of every entry not deleted, of every listpack node.
+func (rs RedisStream) liveEntries(fn func(fields StreamFields)) {
+	for _, item := range rs.Entries {
+		entries, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, entry := range entries {
+			if entry, ok := entry.(map[string]interface{}); ok && entry["hasDeleted"] != "true" {
+				fields, _ := entry["fields"].(StreamFields)
+				fn(fields)
+			}
+		}
+	}
+}
+
+// StreamMessage is an entry of a stream, fields are in the order of the dump.
+type StreamMessage struct {
+	Id      StreamId
+	Deleted bool
+	Fields  StreamFields
+}
+
+type StreamField struct {
+	Field string
+	Value string
+}
+
+// StreamFields are the fields of an entry as saved, a field may be repeated.
+type StreamFields []StreamField
+
+// MarshalJSON writes the fields as an object, in order and with the repeated names.
+func (fs StreamFields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range fs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Field)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// StreamPending is an entry of the PEL of a group, delivered to a consumer but not acknowledged yet.
+type StreamPending struct {
+	Id            StreamId
+	Consumer      string
+	DeliveryTime  uint64
+	DeliveryCount uint64
+}
+
+func parseStreamId(s string) (StreamId, error) {
+	i := strings.IndexByte(s, '-')
+	if i < 0 {
+		return StreamId{}, errors.New("invalid stream id " + s)
+	}
+	ms, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return StreamId{}, err
+	}
+	seq, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		return StreamId{}, err
+	}
+	return StreamId{Ms: ms, Sequence: seq}, nil
+}
+
+func (sd StreamId) Less(other StreamId) bool {
+	return sd.Ms < other.Ms || (sd.Ms == other.Ms && sd.Sequence < other.Sequence)
+}
+
+// Messages returns the entries of the stream sorted by id.
+func (rs RedisStream) Messages() []StreamMessage {
+	var messages []StreamMessage
+	for _, node := range rs.Entries {
+		entries, ok := node.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for id, item := range entries {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			streamId, err := parseStreamId(id)
+			if err != nil {
+				continue
+			}
+			message := StreamMessage{Id: streamId, Deleted: entry["hasDeleted"] == "true"}
+			message.Fields, _ = entry["fields"].(StreamFields)
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Id.Less(messages[j].Id) })
+	return messages
+}
+
+// SCG_INVALID_ENTRIES_READ, the entries read by the group are unknown.
+const streamInvalidEntriesRead = math.MaxUint64
+
+// upgrade derives the metadata of redis 7.0 and 7.2 of a stream saved by an
+// older version, like redis when it loads the stream: the entries added are
+// its length, the first id is its first live entry, the entries read by a
+// group are estimated from its last id and the consumers were active when seen.
+func (rs RedisStream) upgrade() RedisStream {
+	if rs.rdbType < TypeStreamListPacks2 {
+		rs.EntriesAdded = rs.Length
+		rs.MaxDeletedId = StreamId{}
+		rs.FirstId = StreamId{Ms: math.MaxUint64, Sequence: math.MaxUint64} // No entry
+		for _, message := range rs.Messages() {
+			if !message.Deleted {
+				rs.FirstId = message.Id
+				break
+			}
+		}
+	}
+	if rs.rdbType < TypeStreamListPacks3 && len(rs.Groups) > 0 {
+		groups := make([]StreamGroup, 0, len(rs.Groups))
+		for _, group := range rs.Groups {
+			if rs.rdbType < TypeStreamListPacks2 {
+				group.EntriesRead = streamInvalidEntriesRead
+				if lastId, err := parseStreamId(group.LastId); err == nil {
+					group.EntriesRead = rs.estimateEntriesRead(lastId)
 				}
 			}
+			consumers := make([]StreamConsumer, 0, len(group.Consumers))
+			for _, consumer := range group.Consumers {
+				consumer.ActiveTime = consumer.SeenTime
+				consumers = append(consumers, consumer)
+			}
+			group.Consumers = consumers
+			groups = append(groups, group)
 		}
-		return size
+		rs.Groups = groups
 	}
+	rs.rdbType = TypeStreamListPacks3
+	return rs
+}
 
-	return 0
+// estimateEntriesRead returns the entries added up to the id, as
+// streamEstimateDistanceFromFirstEverEntry, streamInvalidEntriesRead when
+// the entries deleted before it are unknown.
+func (rs RedisStream) estimateEntriesRead(id StreamId) uint64 {
+	if rs.EntriesAdded == 0 {
+		return 0
+	}
+	if rs.Length == 0 && !rs.LastId.Less(id) {
+		return rs.EntriesAdded
+	}
+	if id == rs.LastId {
+		return rs.EntriesAdded
+	} else if rs.LastId.Less(id) {
+		return streamInvalidEntriesRead
+	}
+	if rs.MaxDeletedId == (StreamId{}) || rs.MaxDeletedId.Less(rs.FirstId) {
+		if id.Less(rs.FirstId) {
+			return rs.EntriesAdded - rs.Length
+		} else if id == rs.FirstId {
+			return rs.EntriesAdded - rs.Length + 1
+		}
+	}
+	return streamInvalidEntriesRead
+}
+
+// Pending returns the PEL of the group sorted by id, with the consumer owning each entry.
+func (g StreamGroup) Pending() []StreamPending {
+	owners := make(map[string]string)
+	for _, consumer := range g.Consumers {
+		for id := range consumer.PendingEntryList {
+			owners[id] = consumer.Name
+		}
+	}
+	pending := make([]StreamPending, 0, len(g.PendingEntryList))
+	for id, item := range g.PendingEntryList {
+		nack, ok := item.(StreamNACK)
+		if !ok {
+			continue
+		}
+		streamId, err := parseStreamId(id)
+		if err != nil {
+			continue
+		}
+		pending = append(pending, StreamPending{Id: streamId, Consumer: owners[id], DeliveryTime: nack.DeliveryTime, DeliveryCount: nack.DeliveryCount})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Id.Less(pending[j].Id) })
+	return pending
 }
@@ -0,0 +1,35 @@
+package rdb
+
+import (
+	"testing"
+)
+
+// The deleted entries stay in the listpacks, they are neither counted nor measured.
+func TestStreamLiveEntries(t *testing.T) {
+	tests := []struct {
+		key     string
+		entries uint64
+		bytes   uint64
+		deleted int
+	}{
+		{"listpack", 150, 2180, 0},
+		{"trim", 128, 3168, 22},
+		{"test", 1, 4, 0},
+	}
+	streams := decodeStreams(t, readFixture(t, "dump-stream.rdb"))
+	for _, test := range tests {
+		rs := streams[test.key]
+		deleted := 0
+		for _, message := range rs.Messages() {
+			if message.Deleted {
+				deleted++
+			}
+		}
+		if deleted != test.deleted {
+			t.Fatalf("%s has %d deleted entries, want %d", test.key, deleted, test.deleted)
+		}
+		if rs.ValueLen() != test.entries || rs.ConcreteSize() != test.bytes {
+			t.Errorf("%s has %d entries of %d bytes, want %d of %d bytes", test.key, rs.ValueLen(), rs.ConcreteSize(), test.entries, test.bytes)
+		}
+	}
+}
@@ -10,16 +10,13 @@ type StringObject struct {
 	Val   interface{}
 }
 
-func (r *ParseRdb) readString(key KeyObject) error {
-	valBytes, err := r.loadString()
+func (d *Decoder) readString(key KeyObject) error {
+	valBytes, err := d.loadString()
 	if err != nil {
 		return err
 	}
-	valObject := NewStringObject(key, valBytes)
-	//r.d1 = append(r.d1, valObject.String())
-	r.d1 = append(r.d1, valObject)
-	r.d2 <- valObject
-	return nil
+	key.encoding = stringEncoding(valBytes)
+	return d.handler.OnString(NewStringObject(key, valBytes))
 }
 
 func NewStringObject(key KeyObject, val interface{}) StringObject {
@@ -27,7 +24,7 @@ func NewStringObject(key KeyObject, val interface{}) StringObject {
 }
 
 func (s StringObject) String() string {
-	return fmt.Sprintf("{String: {Key: %s, Value:'%s'}}", s.Key(), s.Value())
+	return fmt.Sprintf("{String: {Key: %s, Value:'%s'}}", ToString(s.Field), s.Value())
 }
 
 func (s StringObject) Type() string {
@@ -35,7 +32,15 @@ func (s StringObject) Type() string {
 }
 
 func (s StringObject) Key() string {
-	return ToString(s.Field)
+	return s.Field.Value()
+}
+
+func (s StringObject) Meta() KeyObject {
+	return s.Field
+}
+
+func (s StringObject) Encoding() string {
+	return s.Field.Encoding()
 }
 
 func (s StringObject) Value() string {
@@ -0,0 +1,201 @@
+package rdb
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Rankings of the top keys: by elements (bytes of a string, fields of a hash...),
+// by bytes of the payload and by estimated memory.
+const (
+	TopByElements = "elements"
+	TopByBytes    = "bytes"
+	TopByMemory   = "memory"
+)
+
+var topRankings = []string{TopByElements, TopByBytes, TopByMemory}
+
+// TopKey is a key among the biggest of its type.
+type TopKey struct {
+	DB       uint64 `json:"db"`
+	Key      string `json:"key"`
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	Elements uint64 `json:"elements"`
+	Bytes    uint64 `json:"bytes"`
+	Memory   uint64 `json:"memory"`
+}
+
+func (k TopKey) measure(ranking string) uint64 {
+	switch ranking {
+	case TopByElements:
+		return k.Elements
+	case TopByBytes:
+		return k.Bytes
+	}
+	return k.Memory
+}
+
+// TopKeys keeps the N biggest keys of a type in a bounded min-heap by ranking,
+// the smallest of the top is replaced when a bigger key comes.
+type TopKeys struct {
+	n     int
+	heaps map[string]*topHeap
+}
+
+func NewTopKeys(n int) *TopKeys {
+	t := &TopKeys{n: n, heaps: make(map[string]*topHeap, len(topRankings))}
+	for _, ranking := range topRankings {
+		t.heaps[ranking] = &topHeap{ranking: ranking, keys: make([]TopKey, 0, n)}
+	}
+	return t
+}
+
+func (t *TopKeys) Add(key TopKey) {
+	for _, h := range t.heaps {
+		if h.Len() < t.n {
+			heap.Push(h, key)
+		} else if key.measure(h.ranking) > h.keys[0].measure(h.ranking) {
+			h.keys[0] = key
+			heap.Fix(h, 0)
+		}
+	}
+}
+
+// Keys returns the top keys of the ranking, the biggest first.
+func (t *TopKeys) Keys(ranking string) []TopKey {
+	h := t.heaps[ranking]
+	keys := make([]TopKey, len(h.keys))
+	copy(keys, h.keys)
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].measure(ranking) != keys[j].measure(ranking) {
+			return keys[i].measure(ranking) > keys[j].measure(ranking)
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	return keys
+}
+
+type topHeap struct {
+	ranking string
+	keys    []TopKey
+}
+
+func (h *topHeap) Len() int { return len(h.keys) }
+func (h *topHeap) Less(i, j int) bool {
+	return h.keys[i].measure(h.ranking) < h.keys[j].measure(h.ranking)
+}
+func (h *topHeap) Swap(i, j int)      { h.keys[i], h.keys[j] = h.keys[j], h.keys[i] }
+func (h *topHeap) Push(x interface{}) { h.keys = append(h.keys, x.(TopKey)) }
+func (h *topHeap) Pop() interface{} {
+	key := h.keys[len(h.keys)-1]
+	h.keys = h.keys[:len(h.keys)-1]
+	return key
+}
+
+// Types of the top keys, in the order of the summary.
+var topTypes = append(append([]string{}, turns...), protocol.Module)
+
+func (w *WriterRDB) topKey(k KeyRecord, memory uint64) TopKey {
+	key := TopKey{DB: w.db, Key: k.Key(), Type: redisTypes[k.Type()], Encoding: k.Encoding(), Elements: k.ValueLen(), Bytes: k.ConcreteSize(), Memory: memory}
+	if entry, ok := k.(KeyEntry); ok {
+		// Only the serialized size is known without the value.
+		key.DB, key.Elements = entry.DB, 0
+	}
+	return key
+}
+
+func (w *WriterRDB) addTop(key TopKey, t string) {
+	top, ok := w.Top[t]
+	if !ok {
+		top = NewTopKeys(w.top)
+		w.Top[t] = top
+	}
+	top.Add(key)
+}
+
+// The rankings of the summary, without value there are only the serialized bytes.
+func (w *WriterRDB) rankings() []string {
+	if w.keysOnly {
+		return []string{TopByBytes}
+	}
+	return topRankings
+}
+
+func (w *WriterRDB) printTop() {
+	for _, ranking := range w.rankings() {
+		println(fmt.Sprintf("-------- top %d keys by %s -------\n", w.top, ranking))
+		for _, t := range topTypes {
+			top, ok := w.Top[t]
+			if !ok {
+				continue
+			}
+			for i, key := range top.Keys(ranking) {
+				println(fmt.Sprintf("%2d. %6s '%s' %s", i+1, strings.ToLower(t), key.Key, w.topMeasure(t, ranking, key)))
+			}
+		}
+		println()
+	}
+}
+
+func (w *WriterRDB) topMeasure(t, ranking string, key TopKey) string {
+	switch ranking {
+	case TopByElements:
+		return fmt.Sprintf("has %d %s", key.Elements, units[t])
+	case TopByBytes:
+		return fmt.Sprintf("has %d %s", key.Bytes, w.unit(protocol.String))
+	}
+	return fmt.Sprintf("uses %d bytes of memory", key.Memory)
+}
+
+// flushTop writes the top keys into top.json when the gen-file is json or ndjson, top.csv otherwise.
+func (w *WriterRDB) flushTop() error {
+	suffix := ".csv"
+	if w.fileType == "json" || w.fileType == "ndjson" {
+		suffix = ".json"
+	}
+	fileName, err := generateFileName(w.output, "top", suffix)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	type rankedKey struct {
+		Ranking string `json:"ranking"`
+		Rank    int    `json:"rank"`
+		TopKey
+	}
+	ranked := make([]rankedKey, 0)
+	for _, ranking := range w.rankings() {
+		for _, t := range topTypes {
+			if top, ok := w.Top[t]; ok {
+				for i, key := range top.Keys(ranking) {
+					ranked = append(ranked, rankedKey{Ranking: ranking, Rank: i + 1, TopKey: key})
+				}
+			}
+		}
+	}
+	if suffix == ".json" {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ranked)
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"ranking", "rank", "db", "type", "key", "encoding", "elements", "bytes", "memory"})
+	for _, key := range ranked {
+		writer.Write([]string{key.Ranking, ToString(key.Rank), ToString(key.DB), key.Type, key.Key, key.Encoding,
+			ToString(key.Elements), ToString(key.Bytes), ToString(key.Memory)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
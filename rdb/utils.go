@@ -4,19 +4,35 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/8090Lambert/go-redis-parser/command"
+	"github.com/8090Lambert/go-redis-parser/protocol"
 	"os"
 	"strconv"
 	"strings"
 	"syscall"
 )
 
-func lzfDecompress(in []byte, inLen, outLen int) []byte {
+// Capacity preallocated for lengths read from the stream is limited,
+// a corrupt length must not allocate more than the data really holds.
+const maxPreAlloc = 1 << 16
+
+func allocCap(length uint64) int {
+	if length > maxPreAlloc {
+		return maxPreAlloc
+	}
+	return int(length)
+}
+
+var errLZFCorrupt = errors.New("lzf: compressed data is corrupt")
+
+func lzfDecompress(in []byte, inLen, outLen int) ([]byte, error) {
 	out := make([]byte, outLen)
 	for i, o := 0, 0; i < inLen; {
 		ctrl := int(in[i])
 		i++
 		if ctrl < 1<<5 {
+			if i+ctrl+1 > inLen || o+ctrl+1 > outLen {
+				return nil, errLZFCorrupt
+			}
 			for x := 0; x <= ctrl; x++ {
 				out[o] = in[i]
 				i++
@@ -25,11 +41,20 @@ func lzfDecompress(in []byte, inLen, outLen int) []byte {
 		} else {
 			length := ctrl >> 5
 			if length == 7 {
+				if i >= inLen {
+					return nil, errLZFCorrupt
+				}
 				length += int(in[i])
 				i++
 			}
+			if i >= inLen {
+				return nil, errLZFCorrupt
+			}
 			ref := o - ((ctrl & 0x1f) << 8) - int(in[i]) - 1
 			i++
+			if ref < 0 || o+length+2 > outLen {
+				return nil, errLZFCorrupt
+			}
 			for x := 0; x <= length+1; x++ {
 				out[o] = out[ref]
 				ref++
@@ -38,7 +63,7 @@ func lzfDecompress(in []byte, inLen, outLen int) []byte {
 		}
 	}
 
-	return out
+	return out, nil
 }
 
 func loadZipmapItem(buf *input, readFree bool) ([]byte, error) {
@@ -174,23 +199,44 @@ func loadZiplistEntry(buf *input) ([]byte, error) {
 	return nil, errors.New(fmt.Sprintf("rdb: unknown ziplist header byte: %d", header))
 }
 
-func generateFileName(prefix, suffix string) string {
-	dir := command.Output
+const prefix = "parser" // output file prefix
+
+// CreateGenFile creates the gen-file parser.(csv|json|ndjson|resp|txt) in the output directory of the options.
+func CreateGenFile(opts protocol.Options) (*os.File, error) {
+	suffix := ".csv"
+	if opts.GenFileType == "commands" {
+		suffix = ".txt"
+	} else if opts.GenFileType == "json" || opts.GenFileType == "ndjson" || opts.GenFileType == "resp" {
+		suffix = "." + opts.GenFileType
+	}
+	fileName, err := generateFileName(opts.Output, prefix, suffix)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func generateFileName(dir, prefix, suffix string) (string, error) {
 	if dir == "" {
-		dir, _ = os.Getwd()
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return "", err
+		}
 	}
 
 	if _, err := os.Stat(dir); err != nil && os.IsNotExist(err) {
-		os.Mkdir(dir, 0755)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return "", err
+		}
 	}
 
 	// Check write permission.
 	if err := syscall.Access(dir, syscall.O_RDWR); err != nil {
-		panic(fmt.Sprintf("Path '%s' can not writeable! ", dir))
+		return "", errors.New(fmt.Sprintf("Path '%s' can not writeable! ", dir))
 	}
 
 	fileName := strings.TrimRight(dir, "/") + "/" + prefix + suffix
-	return fileName
+	return fileName, nil
 }
 
 func ToString(i interface{}) string {
@@ -204,6 +250,6 @@ func ToString(i interface{}) string {
 	case []byte:
 		return string(v)
 	default:
-		panic("unknown type")
+		return fmt.Sprintf("%v", v)
 	}
 }
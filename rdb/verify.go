@@ -0,0 +1,47 @@
+package rdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/8090Lambert/go-redis-parser/protocol"
+	"github.com/fatih/color"
+	"os"
+)
+
+// VerifyRdb only validates the file and its checksum, no gen-file is written.
+type VerifyRdb struct {
+	handler          *os.File
+	skipZeroChecksum bool
+}
+
+func NewVerifier(file string, opts protocol.Options) (protocol.Parser, error) {
+	handler, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &VerifyRdb{handler: handler, skipZeroChecksum: opts.SkipZeroChecksum}, nil
+}
+
+func (v *VerifyRdb) Parse() error {
+	defer v.handler.Close()
+	decoder := NewDecoder(v.handler, NopHandler{})
+	decoder.SkipZeroChecksum = v.skipZeroChecksum
+	err := decoder.Decode()
+
+	var checksumErr *ChecksumError
+	if errors.As(err, &checksumErr) {
+		fmt.Println(color.RedString("MISMATCH"), fmt.Sprintf("expected %016x, computed %016x", checksumErr.Expected, checksumErr.Actual))
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if decoder.Version() < VersionChecksum {
+		fmt.Println(color.GreenString("OK"), fmt.Sprintf("RDB version %d has no checksum", decoder.Version()))
+	} else if decoder.Checksum() == 0 {
+		fmt.Println(color.GreenString("OK"), "checksum is disabled (rdbchecksum no)")
+	} else {
+		fmt.Println(color.GreenString("OK"), fmt.Sprintf("checksum %016x", decoder.Checksum()))
+	}
+	return nil
+}
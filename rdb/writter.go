@@ -1,48 +1,102 @@
 package rdb
 
 import (
-	"bufio"
 	"encoding/csv"
 	"fmt"
 	"github.com/8090Lambert/go-redis-parser/protocol"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 )
 
 type WriterRDB struct {
-	Writer      io.Writer
-	KeysCount   uint64
-	KeysSize    uint64
-	Gather      map[string][]uint64
-	Biggest     map[string][]string
-	flag        uint32
-	jsonHandler *bufio.Writer
-	csvHandler  *csv.Writer
-	mu          sync.Mutex
-}
-
-const (
-	beginning = 1
-)
+	Writer    io.Writer
+	KeysCount uint64
+	KeysSize  uint64
+	Gather    map[string][]uint64
+	Biggest   map[string][]string
+	Idle      IdleReport
+	Expiry    ExpiryReport
+	Memory    *MemoryProfile               // Memory of the keys by the redis-bits and redis-ver of the dump
+	Encodings map[string]map[string]uint64 // Keys by type, then by encoding
+	Top       map[string]*TopKeys          // Biggest keys by type, with -top
+	Prefixes  *PrefixTrie                  // Keys by prefix, with -prefix-depth
+	Patterns  *KeyPatterns                 // Keys by inferred pattern, with -patterns
+	prefixTop int                          // Prefixes of the top and children by prefix of the tree
+	patterns  int                          // Patterns of the summary
+	top       int                          // Keys of the rankings, 0 for the biggest key only
+	output    string                       // Directory of the gen-file, where the rankings are written
+	db        uint64                       // Database of the following keys
+	fileType  string
+	keysOnly  bool // Sizes of the keys are the bytes of their serialized values
+	records   recordWriter
+	err       error // First error of the records writer
+	mu        sync.Mutex
+}
+
+// recordWriter writes the records into the gen-file, one implementation by gen-file type.
+// The memory of the keys is estimated once by the WriterRDB, 0 for the other records.
+type recordWriter interface {
+	Write(entity protocol.TypeObject, memory uint64) error
+	Flush() error
+}
 
 var (
-	turns = []string{protocol.String, protocol.Hash, protocol.List, protocol.SortedSet, protocol.Set, protocol.Stream}
-	units = map[string]string{protocol.String: "bytes", protocol.Hash: "fields", protocol.List: "items", protocol.SortedSet: "members", protocol.Set: "members", protocol.Stream: "entries"}
+	// Records of the dump which are not keys, excluded from the summary.
+	nonKeyTypes = map[string]struct{}{protocol.Aux: {}, protocol.SelectDB: {}, protocol.ResizeDB: {}, protocol.Function: {}, protocol.ModuleAux: {}, protocol.Command: {}}
+	turns       = []string{protocol.String, protocol.Hash, protocol.List, protocol.SortedSet, protocol.Set, protocol.Stream}
+	units       = map[string]string{protocol.String: "bytes", protocol.Hash: "fields", protocol.List: "items", protocol.SortedSet: "members", protocol.Set: "members", protocol.Stream: "entries"}
 )
 
-func NewRDBWriter(writer io.Writer, gather map[string][]uint64, biggest map[string][]string) *WriterRDB {
+func NewRDBWriter(writer io.Writer, fileType string, gather map[string][]uint64, biggest map[string][]string) *WriterRDB {
+	return newWriter(writer, protocol.Options{GenFileType: fileType, Batch: commandBatch}, gather, biggest)
+}
+
+// NewSummaryWriter returns a writer gathering the summary of every redis type.
+func NewSummaryWriter(writer io.Writer, opts protocol.Options) *WriterRDB {
+	gather := map[string][]uint64{protocol.String: make([]uint64, 2), protocol.Hash: make([]uint64, 2), protocol.List: make([]uint64, 2), protocol.SortedSet: make([]uint64, 2), protocol.Set: make([]uint64, 2), protocol.Stream: make([]uint64, 2)}
+	biggest := map[string][]string{protocol.String: make([]string, 0, 3), protocol.Hash: make([]string, 0, 3), protocol.List: make([]string, 0, 3), protocol.SortedSet: make([]string, 0, 3), protocol.Set: make([]string, 0, 3), protocol.Stream: make([]string, 0, 3)}
+	return newWriter(writer, opts, gather, biggest)
+}
+
+func newWriter(writer io.Writer, opts protocol.Options, gather map[string][]uint64, biggest map[string][]string) *WriterRDB {
+	fileType, batch := opts.GenFileType, opts.Batch
 	w := &WriterRDB{
 		Writer:    writer,
 		KeysCount: 0,
 		KeysSize:  0,
 		Gather:    gather,
 		Biggest:   biggest,
+		fileType:  fileType,
+		keysOnly:  opts.KeysOnly,
+		Memory:    NewMemoryProfile(),
+		Encodings: make(map[string]map[string]uint64),
+		Top:       make(map[string]*TopKeys),
+		top:       opts.Top,
+		prefixTop: opts.PrefixTop,
+		patterns:  opts.Patterns,
+		output:    opts.Output,
 	}
-	if suffix == ".json" {
-		w.jsonHandler = bufio.NewWriter(writer)
+	if fileType == "json" {
+		w.records = newJSONWriter(writer)
+	} else if fileType == "ndjson" {
+		w.records = newNDJSONWriter(writer)
+	} else if fileType == "memory" {
+		w.records = newMemoryWriter(writer)
+	} else if fileType == "resp" {
+		w.records = newCommandWriter(writer, writeRESP, batch)
+	} else if fileType == "commands" {
+		w.records = newCommandWriter(writer, writeInline, batch)
 	} else {
-		w.csvHandler = csv.NewWriter(writer)
+		w.records = newCSVWriter(writer, opts.KeysOnly)
+	}
+	if opts.PrefixDepth > 0 {
+		w.Prefixes = NewPrefixTrie(opts.PrefixDelimiters, opts.PrefixDepth)
+	}
+	if opts.Patterns > 0 {
+		w.Patterns = NewKeyPatterns()
 	}
 
 	return w
@@ -54,50 +108,214 @@ func (w *WriterRDB) FlushGather() {
 	println(fmt.Sprintf("Sampled %d keys in the keyspace!", w.KeysCount))
 	println(fmt.Sprintf("Total key length in bytes is %d\n", w.KeysSize))
 
-	// Biggest.
-	for _, val := range turns {
-		if len(w.Biggest[val]) > 0 {
-			println(fmt.Sprintf("Biggest %6s found '%s' has %s %s", strings.ToLower(val), w.Biggest[val][0], w.Biggest[val][1], units[val]))
+	// Biggest, or the top keys.
+	if w.top > 0 {
+		w.printTop()
+	} else {
+		for _, val := range turns {
+			if len(w.Biggest[val]) > 0 {
+				println(fmt.Sprintf("Biggest %6s found '%s' has %s %s", strings.ToLower(val), w.Biggest[val][0], w.Biggest[val][1], w.unit(val)))
+			}
 		}
+		println()
 	}
-	println()
 
 	// Gather
 	for _, val := range turns {
 		if len(w.Gather[val]) > 0 {
-			println(fmt.Sprintf("%d %s with %d %s", w.Gather[val][0], strings.ToLower(val), w.Gather[val][1], units[val]))
+			println(fmt.Sprintf("%d %s with %d %s", w.Gather[val][0], strings.ToLower(val), w.Gather[val][1], w.unit(val)))
+		}
+	}
+
+	// Encodings
+	if len(w.Encodings) > 0 {
+		w.printEncodings()
+	}
+
+	// Prefixes
+	if w.Prefixes != nil {
+		w.Prefixes.Print(w.prefixTop)
+	}
+
+	// Patterns
+	if w.Patterns != nil {
+		w.Patterns.Print(w.patterns)
+	}
+
+	// Expiry
+	if !w.Expiry.Empty() {
+		ranking := TopByMemory
+		if w.keysOnly {
+			ranking = TopByBytes
 		}
+		w.Expiry.Print(ranking)
+	}
+
+	// LRU/LFU
+	if !w.Idle.Empty() {
+		w.Idle.Print()
 	}
 }
 
-func (w *WriterRDB) AdditionKV(entity protocol.TypeObject) {
-	if suffix == ".json" {
-		w.mu.Lock()
-		defer w.mu.Unlock()
-		if w.flag&beginning == 0 {
-			w.jsonHandler.WriteString("{" + entity.String())
-			w.flag ^= beginning
-		} else {
-			w.jsonHandler.WriteString("," + entity.String())
+// The values are not decoded with -keys-only, the keys are measured by their serialized size.
+func (w *WriterRDB) unit(t string) string {
+	if w.keysOnly {
+		return "serialized bytes"
+	}
+	return units[t]
+}
+
+// memory estimates the memory of the key records, 0 for the others and the keys without value.
+func (w *WriterRDB) memory(entity protocol.TypeObject) uint64 {
+	if _, ok := entity.(KeyEntry); ok {
+		return 0
+	}
+	if k, ok := entity.(KeyRecord); ok {
+		return w.Memory.Usage(k)
+	}
+	return 0
+}
+
+// rank adds the key to the expiry report, the top keys, the prefixes and the patterns.
+func (w *WriterRDB) rank(k KeyRecord, memory uint64) {
+	key := w.topKey(k, memory)
+	w.Expiry.Add(key, k.Meta())
+	if w.top > 0 {
+		w.addTop(key, k.Type())
+	}
+	if w.Prefixes != nil {
+		w.Prefixes.Add(key.Key, key.Bytes, key.Memory, key.Elements, k.Meta().HasExpire())
+	}
+	if w.Patterns != nil {
+		w.Patterns.Add(key.Key, key.Bytes, key.Memory, key.Elements)
+	}
+}
+
+func (w *WriterRDB) addEncoding(k KeyRecord) {
+	encoding := k.Encoding()
+	if encoding == "" {
+		return
+	}
+	if w.Encodings[k.Type()] == nil {
+		w.Encodings[k.Type()] = make(map[string]uint64)
+	}
+	w.Encodings[k.Type()][encoding]++
+}
+
+// The keys of every type by encoding, to tune the *-max-ziplist-entries and *-max-listpack-entries settings.
+func (w *WriterRDB) printEncodings() {
+	println("\n-------- encodings -------\n")
+	for _, t := range append(turns, protocol.Module) {
+		encodings := make([]string, 0, len(w.Encodings[t]))
+		for encoding := range w.Encodings[t] {
+			encodings = append(encodings, encoding)
 		}
-	} else {
-		w.mu.Lock()
-		defer w.mu.Unlock()
-		if w.flag&beginning == 0 {
-			w.csvHandler.Write([]string{"DataType", "Key", "Value", "Size(bytes)"})
-			w.flag ^= beginning
+		sort.Strings(encodings)
+		for _, encoding := range encodings {
+			println(fmt.Sprintf("%d %s encoded as %s", w.Encodings[t][encoding], strings.ToLower(t), encoding))
 		}
-		w.csvHandler.Write([]string{entity.Type(), entity.Key(), entity.Value(), ToString(entity.ConcreteSize())})
 	}
 }
 
-func (w *WriterRDB) FlushFile() {
-	if w.jsonHandler != nil {
-		// Json End
-		w.jsonHandler.WriteString("}")
-		w.jsonHandler.Flush()
+// Collect writes the record into the gen-file and gathers it into the summary.
+func (w *WriterRDB) Collect(entity protocol.TypeObject) {
+	// The architecture and version of redis are aux fields, before the keys.
+	if aux, ok := entity.(AuxField); ok {
+		w.Memory.OnAux(aux)
+		w.Expiry.OnAux(aux)
+	} else if db, ok := entity.(SelectionDB); ok {
+		w.db = db.Index
+	}
+	// The memory is estimated once, for the gen-file and the summary.
+	memory := w.memory(entity)
+	// AllKV
+	w.write(entity, memory)
+	// Gather && Biggest
+	if _, ok := nonKeyTypes[entity.Type()]; !ok {
+		w.KeysCount += 1
+		w.KeysSize += uint64(len([]byte(entity.Key())))
+		// Gather all keys
+		if _, ok := w.Gather[entity.Type()]; ok {
+			w.Gather[entity.Type()][0] += 1
+			w.Gather[entity.Type()][1] += entity.ValueLen()
+		}
+		// Compare biggest key
+		if len(w.Biggest[entity.Type()]) == 0 {
+			w.Biggest[entity.Type()] = append(w.Biggest[entity.Type()], entity.Key())
+			w.Biggest[entity.Type()] = append(w.Biggest[entity.Type()], strconv.FormatUint(entity.ValueLen(), 10))
+			w.Biggest[entity.Type()] = append(w.Biggest[entity.Type()], strconv.FormatUint(entity.ConcreteSize(), 10))
+		} else if size, _ := strconv.ParseUint(w.Biggest[entity.Type()][2], 10, 64); size < entity.ConcreteSize() {
+			w.Biggest[entity.Type()][0] = entity.Key()
+			w.Biggest[entity.Type()][1] = strconv.FormatUint(entity.ValueLen(), 10)
+			w.Biggest[entity.Type()][2] = strconv.FormatUint(entity.ConcreteSize(), 10)
+		}
+		// LRU idle && LFU freq, encodings
+		if k, ok := entity.(KeyRecord); ok {
+			w.Idle.Add(k)
+			w.addEncoding(k)
+			w.rank(k, memory)
+		}
+	}
+}
+
+func (w *WriterRDB) AdditionKV(entity protocol.TypeObject) {
+	w.write(entity, w.memory(entity))
+}
+
+func (w *WriterRDB) write(entity protocol.TypeObject, memory uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.records.Write(entity, memory); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// FlushFile flushes the gen-file, the first error of the writes is returned.
+func (w *WriterRDB) FlushFile() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.records.Flush(); err != nil && w.err == nil {
+		w.err = err
 	}
-	if w.csvHandler != nil {
-		w.csvHandler.Flush()
+	if w.top > 0 && w.err == nil {
+		w.err = w.flushTop()
 	}
+	return w.err
+}
+
+// csvWriter writes a line by record, with the metadata and the memory of keys
+// as last columns. With keysOnly, a line by key without its value.
+type csvWriter struct {
+	handler  *csv.Writer
+	header   bool
+	keysOnly bool
+}
+
+func newCSVWriter(writer io.Writer, keysOnly bool) *csvWriter {
+	return &csvWriter{handler: csv.NewWriter(writer), keysOnly: keysOnly}
+}
+
+func (c *csvWriter) Write(entity protocol.TypeObject, memory uint64) error {
+	if !c.header {
+		if c.keysOnly {
+			c.handler.Write([]string{"DB", "Key", "Type", "Expire", "Expire(ms)", "Size(bytes)", "Encoding"})
+		} else {
+			c.handler.Write([]string{"DataType", "Key", "Value", "Size(bytes)", "Expire", "Expire(ms)", "Idle(s)", "Freq", "Memory(bytes)", "Encoding"})
+		}
+		c.header = true
+	}
+	if k, ok := entity.(KeyEntry); ok {
+		meta := k.Field.columns()
+		return c.handler.Write([]string{ToString(k.DB), k.Key(), redisTypes[k.Type()], meta[0], meta[1], ToString(k.Size), k.Encoding()})
+	}
+	meta := []string{"", "", "", "", "", ""}
+	if k, ok := entity.(KeyRecord); ok {
+		meta = append(k.Meta().columns(), ToString(memory), k.Encoding())
+	}
+	return c.handler.Write(append([]string{entity.Type(), entity.Key(), entity.Value(), ToString(entity.ConcreteSize())}, meta...))
+}
+
+func (c *csvWriter) Flush() error {
+	c.handler.Flush()
+	return c.handler.Error()
 }
@@ -2,6 +2,7 @@ package rdb
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/8090Lambert/go-redis-parser/protocol"
 	"strconv"
@@ -18,39 +19,37 @@ type SortedSetEntry struct {
 	Score float64
 }
 
-func (r *ParseRdb) readZSet(key KeyObject, t byte) error {
-	length, _, err := r.loadLen()
+func (d *Decoder) readZSet(key KeyObject, t byte) error {
+	length, _, err := d.loadLen()
 	if err != nil {
 		return err
 	}
-	sortedSet := SortedSet{Field: key, Len: length, Entries: make([]SortedSetEntry, 0, length)}
+	sortedSet := SortedSet{Field: key, Len: length, Entries: make([]SortedSetEntry, 0, allocCap(length))}
 	for i := uint64(0); i < length; i++ {
-		member, err := r.loadString()
+		member, err := d.loadString()
 		if err != nil {
 			return err
 		}
 		var score float64
 		if t == TypeZset2 {
-			score, err = r.loadBinaryFloat()
+			score, err = d.loadBinaryFloat()
 		} else {
-			score, err = r.loadFloat()
+			score, err = d.loadFloat()
 		}
 		if err != nil {
 			return err
 		}
 		sortedSet.Entries = append(sortedSet.Entries, SortedSetEntry{Field: ToString(member), Score: score})
 	}
-	//r.d1 = append(r.d1, sortedSet.String())
-	r.d1 = append(r.d1, sortedSet)
-	r.d2 <- sortedSet
-	return nil
+	return d.handler.OnZSet(sortedSet)
 }
 
-func (r *ParseRdb) readZipListSortSet(key KeyObject) error {
-	b, err := r.loadString()
+func (d *Decoder) readZipListSortSet(key KeyObject) error {
+	b, err := d.loadString()
 	if err != nil {
 		return err
 	}
+	key.pack(b)
 	buf := newInput(b)
 	cardinality, err := loadZiplistLength(buf)
 	if err != nil {
@@ -74,10 +73,32 @@ func (r *ParseRdb) readZipListSortSet(key KeyObject) error {
 		}
 		sortedSet.Entries = append(sortedSet.Entries, SortedSetEntry{Field: ToString(member), Score: score})
 	}
-	//r.d1 = append(r.d1, sortedSet.String())
-	r.d1 = append(r.d1, sortedSet)
-	r.d2 <- sortedSet
-	return nil
+	return d.handler.OnZSet(sortedSet)
+}
+
+func (d *Decoder) readListPackSortSet(key KeyObject) error {
+	b, err := d.loadString()
+	if err != nil {
+		return err
+	}
+	key.pack(b)
+	items, err := loadListPack(b)
+	if err != nil {
+		return err
+	}
+	if len(items)%2 != 0 {
+		return errors.New("rdb: sorted set listpack has odd number of entries")
+	}
+
+	sortedSet := SortedSet{Field: key, Len: uint64(len(items) / 2), Entries: make([]SortedSetEntry, 0, len(items)/2)}
+	for i := 0; i < len(items); i += 2 {
+		score, err := strconv.ParseFloat(string(items[i+1]), 64)
+		if err != nil {
+			return err
+		}
+		sortedSet.Entries = append(sortedSet.Entries, SortedSetEntry{Field: ToString(items[i]), Score: score})
+	}
+	return d.handler.OnZSet(sortedSet)
 }
 
 func (zs SortedSet) Type() string {
@@ -85,11 +106,19 @@ func (zs SortedSet) Type() string {
 }
 
 func (zs SortedSet) String() string {
-	return fmt.Sprintf("{SortedSet: {Key: %s, Len: %d, Entries: %s}}", zs.Key(), zs.Len, zs.Value())
+	return fmt.Sprintf("{SortedSet: {Key: %s, Len: %d, Entries: %s}}", ToString(zs.Field), zs.Len, zs.Value())
 }
 
 func (zs SortedSet) Key() string {
-	return ToString(zs.Field)
+	return zs.Field.Value()
+}
+
+func (zs SortedSet) Meta() KeyObject {
+	return zs.Field
+}
+
+func (zs SortedSet) Encoding() string {
+	return zs.Field.Encoding()
 }
 
 func (zs SortedSet) Value() string {
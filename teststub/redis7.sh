@@ -0,0 +1,56 @@
+#!/bin/sh
+# Saves teststub/redis7-<version>.rdb with the redis-server and redis-cli 7.x
+# in the PATH, a key of every listpack encoding of Redis 7 (hash, sorted set,
+# set, quicklist with packed and plain nodes, stream with consumer groups),
+# then checks it with -verify and parses it.
+#
+#   $ sh teststub/redis7.sh
+set -e
+
+port=${PORT:-6399}
+dir=$(mktemp -d)
+stub=$(cd "$(dirname "$0")" && pwd)
+cli="redis-cli -p $port"
+
+redis-server --port "$port" --dir "$dir" --dbfilename dump.rdb --save "" --appendonly no \
+	--enable-debug-command yes --list-max-listpack-size 4 --daemonize yes
+trap '$cli shutdown nosave >/dev/null 2>&1 || true; rm -rf "$dir"' EXIT
+until $cli ping >/dev/null 2>&1; do sleep 0.1; done
+
+version=$($cli info server | sed -n 's/^redis_version:\([0-9.]*\).*/\1/p')
+case "$version" in
+7.*) ;;
+*) echo "redis $version, want 7.x" >&2; exit 1 ;;
+esac
+
+# listpack encodings
+$cli hset hash:listpack f1 v1 f2 2 >/dev/null
+$cli zadd zset:listpack 1 m1 2.5 m2 >/dev/null
+$cli sadd set:listpack a b c >/dev/null # listpack since 7.2, intset before
+$cli sadd set:intset 1 2 3 >/dev/null
+$cli rpush list:packed a b c d e f g h i 1 2 3 >/dev/null # 3 packed nodes
+# plain node: an element bigger than the packed threshold
+$cli debug quicklist-packed-threshold 100 >/dev/null
+$cli rpush list:plain small "$(printf '%0200d' 7)" tail >/dev/null
+$cli set string:expire v px 100000000 >/dev/null
+
+# stream with deleted entries, a group, a consumer and pending entries
+$cli xadd stream:groups 1-1 f v >/dev/null
+$cli xadd stream:groups 2-1 f v2 >/dev/null
+$cli xadd stream:groups 3-1 f v3 >/dev/null
+$cli xdel stream:groups 2-1 >/dev/null
+$cli xgroup create stream:groups g1 0 >/dev/null
+$cli xreadgroup group g1 c1 count 1 streams stream:groups ">" >/dev/null
+
+$cli function load "#!lua name=redis7lib
+redis.register_function('redis7f', function() return 1 end)" >/dev/null
+
+$cli -n 1 set string:db1 v >/dev/null
+
+$cli save >/dev/null
+cp "$dir/dump.rdb" "$stub/redis7-$version.rdb"
+
+cd "$stub/.."
+go run . -rdb "teststub/redis7-$version.rdb" -verify
+go run . -rdb "teststub/redis7-$version.rdb" -o "$dir" -type json
+echo "teststub/redis7-$version.rdb"